@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+	"github.com/hw-cs-reps/platform/routes"
+
+	"github.com/urfave/cli/v2"
+)
+
+// CmdExport represents a command-line command
+// which exports tickets.
+var CmdExport = &cli.Command{
+	Name:  "export",
+	Usage: "Export tickets with their upvotes and representatives' comments",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "format", Aliases: []string{"f"}, Value: "csv", Usage: "`FORMAT` to export to: csv, json or md"},
+		&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Usage: "write to `FILE` instead of standard output"},
+		&cli.StringFlag{Name: "category", Usage: "only export tickets of the course `CODE`"},
+		&cli.StringFlag{Name: "degree", Usage: "only export tickets of the courses of the degree `CODE`"},
+		&cli.StringFlag{Name: "status", Value: "all", Usage: "only export tickets with the `STATUS`, or any active, closed or all statuses"},
+		&cli.StringFlag{Name: "from", Usage: "only export tickets posted from `DATE` (YYYY-MM-DD)"},
+		&cli.StringFlag{Name: "to", Usage: "only export tickets posted until `DATE` (YYYY-MM-DD)"},
+		&cli.BoolFlag{Name: "archived", Usage: "export archived tickets instead of current ones"},
+	},
+	Action: export,
+}
+
+func export(clx *cli.Context) (err error) {
+	config.LoadConfig()
+	engine := models.SetupEngine()
+	defer engine.Close()
+
+	out := os.Stdout
+	if path := clx.String("output"); path != "" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	return routes.ExportTickets(out, routes.ExportOptions{
+		Format:   routes.ExportFormat(clx.String("format")),
+		Category: clx.String("category"),
+		Degree:   clx.String("degree"),
+		Status:   clx.String("status"),
+		From:     clx.String("from"),
+		To:       clx.String("to"),
+		Archived: clx.Bool("archived"),
+	})
+}
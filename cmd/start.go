@@ -36,6 +36,7 @@ func start(clx *cli.Context) (err error) {
 	config.LoadConfig()
 	engine := models.SetupEngine()
 	defer engine.Close()
+	routes.StartArchiver()
 
 	// Run emmanuel
 	m := emmanuel.Classic()
@@ -76,6 +77,13 @@ func start(clx *cli.Context) (err error) {
 				}
 				return str.String()
 			},
+			"Dict": func(kv ...interface{}) map[string]interface{} {
+				d := make(map[string]interface{}, len(kv)/2)
+				for i := 0; i+1 < len(kv); i += 2 {
+					d[fmt.Sprint(kv[i])] = kv[i+1]
+				}
+				return d
+			},
 		}},
 		IndentJSON: true,
 	}))
@@ -109,41 +117,134 @@ func start(clx *cli.Context) (err error) {
 
 	m.Get("/", routes.HomepageHandler)
 	m.Get("/preview", routes.PreviewHandler)
+	m.Get("/search", routes.SearchHandler)
 	m.Group("/tickets", func() {
 		m.Get("", routes.TicketsHandler)
 		m.Get("/cat/:category", routes.TicketsHandler)
 		m.Get("/deg/:degree", routes.TicketsHandler)
+		m.Get("/label/:label", routes.TicketsHandler)
+		m.Get("/assigned", routes.RequireAdmin, routes.AssignedTicketsHandler)
+		m.Get("/archive", routes.ArchivedTicketsHandler)
 		m.Post("", csrf.Validate, routes.PostTicketSortHandler)
 		m.Post("/cat/:category", csrf.Validate, routes.PostTicketSortHandler)
 		m.Post("/deg/:degree", csrf.Validate, routes.PostTicketSortHandler)
+		m.Post("/label/:label", csrf.Validate, routes.PostTicketSortHandler)
 		m.Get("/new", routes.NewTicketHandler)
-		m.Post("/new", csrf.Validate, routes.PostNewTicketHandler)
+		m.Post("/new", routes.LimitUploads, csrf.Validate, routes.PostNewTicketHandler)
 		m.Group("/:id", func() {
 			m.Get("", routes.TicketPageHandler)
 			m.Post("", csrf.Validate, routes.PostTicketPageHandler) // comment post
 			m.Post("/upvote", csrf.Validate, routes.UpvoteTicketHandler)
+			m.Post("/retract", csrf.Validate, routes.RetractVoteHandler)
+			m.Post("/follow", csrf.Validate, routes.PostTicketFollowHandler)
+			m.Get("/history", routes.TicketHistoryHandler)
+			m.Post("/report", csrf.Validate, routes.PostTicketReportHandler)
+			m.Post("/report/:cid", csrf.Validate, routes.PostCommentReportHandler)
 
 			// Admin
-			m.Post("/resolve", routes.RequireAdmin, csrf.Validate, routes.ResolveTicketHandler)
+			m.Post("/status", routes.RequireAdmin, csrf.Validate, routes.PostTicketStatusHandler)
 			m.Post("/edit", routes.RequireAdmin, csrf.Validate, routes.PostTicketEditHandler)
+			m.Post("/assign", routes.RequireAdmin, csrf.Validate, routes.PostTicketAssignHandler)
+			m.Post("/labels", routes.RequireAdmin, csrf.Validate, routes.PostTicketLabelsHandler)
+			m.Post("/duplicate", routes.RequireAdmin, csrf.Validate, routes.PostTicketDuplicateHandler)
+			m.Post("/pin", routes.RequireAdmin, csrf.Validate, routes.PostTicketPinHandler)
+			m.Post("/lock", routes.RequireAdmin, csrf.Validate, routes.PostTicketLockHandler)
+			m.Post("/answer", routes.RequireAdmin, csrf.Validate, routes.PostTicketAnswerHandler)
+			m.Post("/archive", routes.RequireAdmin, csrf.Validate, routes.PostTicketArchiveHandler)
+			m.Post("/escalate", routes.RequireAdmin, csrf.Validate, routes.PostTicketEscalateHandler)
+			m.Post("/polls", routes.RequireAdmin, csrf.Validate, routes.PostTicketPollHandler)
+			m.Post("/history/:rid/restore", routes.RequireAdmin, csrf.Validate, routes.PostTicketRestoreHandler)
 			m.Post("/delete", routes.RequireAdmin, csrf.Validate, routes.PostTicketDeleteHandler)
 			m.Post("/del/:cid", routes.RequireAdmin, csrf.Validate, routes.PostCommentDeleteHandler)
 		})
 	})
 
+	m.Group("/labels", func() {
+		m.Get("", routes.LabelsHandler)
+		m.Post("", csrf.Validate, routes.PostNewLabelHandler)
+		m.Post("/:id/edit", csrf.Validate, routes.PostLabelEditHandler)
+		m.Post("/:id/delete", csrf.Validate, routes.PostLabelDeleteHandler)
+	}, routes.RequireAdmin)
+
+	m.Group("/queue", func() {
+		m.Get("", routes.QueueHandler)
+		m.Post("/tickets", csrf.Validate, routes.PostQueueTicketsHandler)
+		m.Post("/comments", csrf.Validate, routes.PostQueueCommentsHandler)
+	}, routes.RequireAdmin)
+
+	m.Group("/export", func() {
+		m.Get("", routes.ExportHandler)
+		m.Get("/download", routes.ExportDownloadHandler)
+	}, routes.RequireAdmin)
+
+	m.Group("/reports", func() {
+		m.Get("", routes.ReportsHandler)
+		m.Post("/:kind/:id", csrf.Validate, routes.PostReportReviewHandler)
+	}, routes.RequireAdmin)
+
+	m.Group("/polls/:id", func() {
+		m.Post("/vote", routes.PostPollVoteHandler)
+		m.Post("/close", routes.RequireAdmin, routes.PostPollCloseHandler)
+		m.Post("/delete", routes.RequireAdmin, routes.PostPollDeleteHandler)
+	}, csrf.Validate)
+
+	m.Get("/attachments/:hash", routes.AttachmentHandler)
+	m.Group("/follow", func() {
+		m.Get("/confirm/:token", routes.FollowConfirmHandler)
+		m.Get("/unsubscribe/:token", routes.FollowUnsubscribeHandler)
+	})
+
 	m.Group("/a", func() {
 		m.Get("", routes.AnnouncementsHandler)
 		m.Group("/:id", func() {
 			m.Get("", routes.AnnouncementHandler)
+			m.Get("/history", routes.AnnouncementHistoryHandler)
+			m.Post("/history/:rid/restore", routes.RequireAdmin, csrf.Validate, routes.PostAnnouncementRestoreHandler)
 			m.Post("/edit", routes.RequireAdmin, csrf.Validate, routes.PostAnnouncementEditHandler)
+			m.Post("/polls", routes.RequireAdmin, csrf.Validate, routes.PostAnnouncementPollHandler)
 			m.Post("/delete", routes.RequireAdmin, csrf.Validate, routes.PostAnnouncementDeleteHandler)
 		})
 
 		// Admin
 		m.Get("/new", routes.RequireAdmin, routes.NewAnnouncementHandler)
-		m.Post("/new", routes.RequireAdmin, routes.PostNewAnnouncementHandler)
+		m.Post("/new", routes.RequireAdmin, routes.LimitUploads, routes.PostNewAnnouncementHandler)
 	})
 
+	m.Group("/api/v1", func() {
+		m.Group("/tickets", func() {
+			m.Get("", routes.APITicketsHandler)
+			m.Post("", routes.APIPostTicketHandler)
+			m.Get("/similar", routes.APISimilarTicketsHandler)
+			m.Group("/:id", func() {
+				m.Get("", routes.APITicketHandler)
+				m.Post("/upvote", routes.APIUpvoteTicketHandler)
+				m.Delete("/upvote", routes.APIRetractVoteHandler)
+				m.Get("/comments", routes.APICommentsHandler)
+				m.Post("/comments", routes.APIPostCommentHandler)
+
+				// Admin
+				m.Post("/status", routes.APIRequireAdmin, routes.APITicketStatusHandler)
+				m.Post("/duplicate", routes.APIRequireAdmin, routes.APIDuplicateTicketHandler)
+				m.Post("/pin", routes.APIRequireAdmin, routes.APIPinTicketHandler)
+				m.Post("/lock", routes.APIRequireAdmin, routes.APILockTicketHandler)
+				m.Post("/answer", routes.APIRequireAdmin, routes.APIAnswerTicketHandler)
+				m.Post("/archive", routes.APIRequireAdmin, routes.APIArchiveTicketHandler)
+				m.Delete("", routes.APIRequireAdmin, routes.APIDeleteTicketHandler)
+				m.Delete("/comments/:cid", routes.APIRequireAdmin, routes.APIDeleteCommentHandler)
+			})
+		})
+		m.Group("/announcements", func() {
+			m.Get("", routes.APIAnnouncementsHandler)
+			m.Get("/:id", routes.APIAnnouncementHandler)
+
+			// Admin
+			m.Post("", routes.APIRequireAdmin, routes.APIPostAnnouncementHandler)
+			m.Delete("/:id", routes.APIRequireAdmin, routes.APIDeleteAnnouncementHandler)
+		})
+		m.Get("/search", routes.APISearchHandler)
+		m.Any("/*", routes.APINotFoundHandler)
+	}, routes.APIRequireJSON)
+
 	m.Get("/complaints", routes.ComplaintsHandler)
 	m.Post("/complaints", csrf.Validate, routes.PostComplaintsHandler)
 	m.Get("/courses", routes.CoursesHandler)
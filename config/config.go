@@ -6,6 +6,8 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -30,13 +32,19 @@ type Configuration struct {
 	SiteName        string                // SiteName is the name of the site.
 	SiteScope       string                // SiteScope is the campus, department, and university year of the site.
 	SitePort        string                // SitePort is the port to run the web server on.
+	SiteURL         string                // SiteURL is the public address of the site, used in links sent by email.
 	VoterPepper     string                // VoterPepper is the salt used in the voter ID hash.
+	FollowerKey     string                // FollowerKey is the secret used to encrypt the emails of ticket followers.
 	DevMode         bool                  // DevMode is whether to disable authentication for development.
 	UniEmailDomain  string                // UniEmailDomain is the university domain for login.
 	EmailAddress    string                // EmailAddress is the email address which sends the OTPs.
 	EmailPassword   string                // EmailPassword is the password of the email used to send OTPs.
 	EmailSMTPServer string                // EmailSMTPServer is the SMTP server including the port.
 	DBConfig        DatabaseConfiguration // DBConfig is the database configuration.
+	Attachments     AttachmentSettings    // Attachments is the configuration of uploaded files.
+	HotDecay        HotDecaySettings      // HotDecay is how the score of hot tickets decays with age.
+	Archive         ArchiveSettings       // Archive is when tickets are archived automatically.
+	RateLimits      RateLimitSettings     // RateLimits is how often anonymous users may post and vote.
 	InstanceConfig  InstanceSettings      // InstanceSettings is instance-specific configuration.
 }
 
@@ -51,6 +59,85 @@ type InstanceSettings struct {
 	ClassReps        []ClassRepresentative
 	Courses          []Course
 	Lecturers        []Lecturer
+	ContentFilter    ContentFilterSettings
+	// PreModerateTickets hides every anonymous ticket until it is approved.
+	PreModerateTickets bool
+	// ReportThreshold is how many reports hide a ticket or comment until it is
+	// reviewed, or zero to never hide reported content.
+	ReportThreshold int
+}
+
+const (
+	// FilterReject refuses posts caught by the content filter.
+	FilterReject = "reject"
+	// FilterQueue holds posts caught by the content filter for review.
+	FilterQueue = "queue"
+)
+
+// ContentFilterSettings represents the checks anonymous tickets and comments
+// go through before being posted. Class representatives are exempt.
+type ContentFilterSettings struct {
+	BlockedWords    []string // BlockedWords are whole words matched ignoring case.
+	BlockedPatterns []string // BlockedPatterns are regular expressions.
+	MaxLinks        int      // MaxLinks is the most links a post may have, or zero for no limit.
+	RepeatPeriod    int64    // RepeatPeriod is how long in seconds identical posts are caught for, or zero to allow them.
+	Action          string   // Action is FilterReject or FilterQueue.
+
+	words    []blockedRegexp
+	patterns []blockedRegexp
+}
+
+// blockedRegexp is a compiled blocked word or pattern.
+type blockedRegexp struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// Compile compiles the blocked words and patterns, so they aren't compiled for
+// every post. It must be called whenever they change. Invalid patterns are
+// logged and left out.
+func (s *ContentFilterSettings) Compile() {
+	s.words, s.patterns = nil, nil
+	for _, w := range s.BlockedWords {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		// \b only matches next to a word character, so it would never match
+		// words starting or ending with punctuation.
+		re := regexp.MustCompile(`(?i)(^|\W)` + regexp.QuoteMeta(w) + `(\W|$)`)
+		s.words = append(s.words, blockedRegexp{w, re})
+	}
+	for _, p := range s.BlockedPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Printf("Invalid blocked pattern %q: %v\n", p, err)
+			continue
+		}
+		s.patterns = append(s.patterns, blockedRegexp{p, re})
+	}
+}
+
+// BlockedWord returns the first blocked word in a post, or an empty string if
+// it has none.
+func (s *ContentFilterSettings) BlockedWord(post string) string {
+	for _, w := range s.words {
+		if w.re.MatchString(post) {
+			return w.source
+		}
+	}
+	return ""
+}
+
+// BlockedPattern returns the first blocked pattern matching a post, or an
+// empty string if none do.
+func (s *ContentFilterSettings) BlockedPattern(post string) string {
+	for _, p := range s.patterns {
+		if p.re.MatchString(post) {
+			return p.source
+		}
+	}
+	return ""
 }
 
 // ExternalResource holds the information to a hyperlink
@@ -70,6 +157,7 @@ type Course struct {
 
 type Lecturer struct {
 	Name, Email, Office, Updated string
+	Courses                      []string // Courses are the codes of the courses taught, for escalating tickets.
 }
 
 // DBType represents the type of the database driver which will be used.
@@ -93,12 +181,53 @@ type DatabaseConfiguration struct {
 	Path     string // Path refers to the database file path (SQLite only).
 }
 
+// AttachmentSettings represents the limits and storage of files attached to
+// tickets and announcements.
+type AttachmentSettings struct {
+	Path     string // Path is the directory where attachments are stored.
+	MaxSize  int64  // MaxSize is the maximum size of a file in bytes.
+	MaxFiles int    // MaxFiles is the maximum number of files attached to a post.
+}
+
+// HotDecaySettings represents how the score of a ticket, from its upvotes,
+// decays with its age when sorting by hot tickets.
+type HotDecaySettings struct {
+	Period  int64   // Period is the age in seconds after which the score decays.
+	Gravity float64 // Gravity is the power of the age in periods which the score is divided by.
+}
+
+// ArchiveSettings represents when tickets are archived automatically. Either
+// rule is disabled by setting it to zero.
+type ArchiveSettings struct {
+	StaleDays    int // StaleDays is how long a ticket may go without votes or comments.
+	ResolvedDays int // ResolvedDays is how long a resolved ticket stays open before it is closed.
+}
+
+// RateLimit represents how many times an action may be done in a period. The
+// limit is disabled by setting Count to zero.
+type RateLimit struct {
+	Count  int   // Count is how many times the action may be done in the period.
+	Period int64 // Period is the length of the period in seconds.
+}
+
+// RateLimitSettings represents the limits of anonymous users, who are
+// identified by their voter ID and session. Class representatives are exempt.
+type RateLimitSettings struct {
+	Tickets  RateLimit // Tickets limits posting tickets.
+	Comments RateLimit // Comments limits commenting on tickets.
+	Votes    RateLimit // Votes limits upvoting tickets.
+	Follows  RateLimit // Follows limits following tickets by email.
+	Reports  RateLimit // Reports limits reporting tickets and comments.
+}
+
 func newConfig() Configuration {
 	return Configuration{
 		SiteName:        "Platform",
 		SiteScope:       "Edinburgh · MACS · Year 4",
 		SitePort:        "8080",
+		SiteURL:         "http://localhost:8080",
 		VoterPepper:     uuid.New().String(),
+		FollowerKey:     uuid.New().String(),
 		DevMode:         true,
 		UniEmailDomain:  "@hw.ac.uk",
 		EmailAddress:    "noreply@example.com",
@@ -112,12 +241,38 @@ func newConfig() Configuration {
 			Password: "passwordhere",
 			Path:     "data.db",
 		},
+		Attachments: AttachmentSettings{
+			Path:     "attachments",
+			MaxSize:  5 << 20,
+			MaxFiles: 4,
+		},
+		HotDecay: HotDecaySettings{
+			Period:  86400,
+			Gravity: 1,
+		},
+		Archive: ArchiveSettings{
+			StaleDays:    120,
+			ResolvedDays: 14,
+		},
+		RateLimits: RateLimitSettings{
+			Tickets:  RateLimit{Count: 3, Period: 3600},
+			Comments: RateLimit{Count: 10, Period: 600},
+			Votes:    RateLimit{Count: 30, Period: 600},
+			Follows:  RateLimit{Count: 5, Period: 3600},
+			Reports:  RateLimit{Count: 5, Period: 3600},
+		},
 		InstanceConfig: InstanceSettings{
 			ShowNotice:   true,
 			NoticeTitle:  "Privacy Policy Update",
 			NoticeText:   "The privacy policy has been updated. Please consider re-reading it for your peace of mind.",
 			NoticeLink:   "/privacy",
 			NoticeColour: "alert-green", // alert-green, alert-yellow, alert-red, alert-grey
+			ContentFilter: ContentFilterSettings{
+				MaxLinks:     5,
+				RepeatPeriod: 3600,
+				Action:       FilterQueue,
+			},
+			ReportThreshold: 3,
 			RequestChatEmail: `Hello!
 
 You have requested access to the Edinburgh MACS Year 4 group chat. You may join
@@ -479,7 +634,8 @@ func init() {
 // if it doesn't exist.
 func LoadConfig() {
 	var err error
-	if _, err = toml.DecodeFile(WorkingDir+"/"+ConfigPath, &Config); err != nil {
+	var meta toml.MetaData
+	if meta, err = toml.DecodeFile(WorkingDir+"/"+ConfigPath, &Config); err != nil {
 		log.Printf("Cannot load config file. Error: %s", err)
 		if os.IsNotExist(err) {
 			log.Println("Generating new configuration file, as it doesn't exist")
@@ -498,6 +654,42 @@ func LoadConfig() {
 		}
 	}
 
+	// Settings missing from the configuration are filled in and saved. Only a
+	// configuration which was loaded is saved, so a broken file is never
+	// overwritten.
+	filled := false
+	if err == nil && Config.FollowerKey == "" {
+		log.Println("Generating a follower key, as the configuration has none")
+		Config.FollowerKey = uuid.New().String()
+		filled = true
+	}
+	// Configurations from before an action was rate limited get its default
+	// limit, rather than none.
+	defaults := newConfig().RateLimits
+	for _, l := range []struct {
+		name  string
+		limit *RateLimit
+		def   RateLimit
+	}{
+		{"Tickets", &Config.RateLimits.Tickets, defaults.Tickets},
+		{"Comments", &Config.RateLimits.Comments, defaults.Comments},
+		{"Votes", &Config.RateLimits.Votes, defaults.Votes},
+		{"Follows", &Config.RateLimits.Follows, defaults.Follows},
+		{"Reports", &Config.RateLimits.Reports, defaults.Reports},
+	} {
+		if err == nil && !meta.IsDefined("RateLimits", l.name) {
+			*l.limit = l.def
+			filled = true
+		}
+	}
+	if filled {
+		if err := SaveConfig(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	Config.InstanceConfig.ContentFilter.Compile()
+
 	has := make(map[string]bool)
 	for _, c := range Config.InstanceConfig.Courses {
 		for _, dc := range c.DegreeCode {
@@ -49,3 +49,24 @@ func EmailCode(to string, code string) error {
 
 	return Email([]string{to}, config.Config.SiteName+" login code", message)
 }
+
+// EmailFollowConfirmation asks someone to confirm following a ticket.
+func EmailFollowConfirmation(to, ticketTitle, confirmURL, unsubscribeURL string) error {
+	message := "Hello!\nPlease confirm that you want to receive updates about the ticket \"" +
+		ticketTitle + "\" by opening the following link:\n\n" + confirmURL + "\n\n" +
+		"Ignore this message if you have not asked to follow the ticket.\n" +
+		"To stop receiving any emails about it, open " + unsubscribeURL + "\n\n\n" +
+		"- " + config.Config.SiteName + "\nThis message is sent from an unmonitored inbox."
+
+	return Email([]string{to}, "Confirm following \""+ticketTitle+"\"", message)
+}
+
+// EmailTicketUpdate tells a follower of a ticket about an update to it.
+func EmailTicketUpdate(to, ticketTitle, update, ticketURL, unsubscribeURL string) error {
+	message := "Hello!\nThere is an update to the ticket \"" + ticketTitle + "\":\n\n" +
+		update + "\n\nView the ticket at " + ticketURL + "\n\n" +
+		"To stop receiving updates about this ticket, open " + unsubscribeURL + "\n\n\n" +
+		"- " + config.Config.SiteName + "\nThis message is sent from an unmonitored inbox."
+
+	return Email([]string{to}, "Update to \""+ticketTitle+"\"", message)
+}
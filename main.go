@@ -19,6 +19,7 @@ func main() {
 		Version: VERSION,
 		Commands: []*cli.Command{
 			cmd.CmdStart,
+			cmd.CmdExport,
 		},
 	}
 
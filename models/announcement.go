@@ -1,6 +1,10 @@
 package models
 
-import "errors"
+import (
+	"errors"
+
+	"xorm.io/xorm"
+)
 
 // Announcement represents an announcement
 type Announcement struct {
@@ -15,13 +19,17 @@ type Announcement struct {
 
 // AddAnnouncement inserts a new announcement into the database
 func AddAnnouncement(a *Announcement) (err error) {
-	_, err = engine.Insert(a)
+	if _, err = engine.Insert(a); err == nil {
+		searchRefresh(SearchAnnouncement, a.AnnouncementID)
+	}
 	return err
 }
 
 // UpdateAnnouncement updates an announcement in the database.
 func UpdateAnnouncement(a *Announcement) (err error) {
-	_, err = engine.ID(a.AnnouncementID).Update(a)
+	if _, err = engine.ID(a.AnnouncementID).Update(a); err == nil {
+		searchRefresh(SearchAnnouncement, a.AnnouncementID)
+	}
 	return
 }
 
@@ -44,15 +52,46 @@ func GetAnnouncements() (announcements []Announcement) {
 	return announcements
 }
 
+// CountAnnouncements returns the number of announcements.
+func CountAnnouncements() int64 {
+	total, _ := engine.Count(new(Announcement))
+	return total
+}
+
+// GetAnnouncementsRange fetches at most limit announcements starting from
+// start, newest first.
+func GetAnnouncementsRange(start, limit int) (announcements []Announcement) {
+	engine.Desc("created_unix").Limit(limit, start).Find(&announcements)
+	return
+}
+
 // DelAnnouncement deletes a announcement based on the AnnouncementID
-func DelAnnouncement(id int64) (err error) {
-	_, err = engine.ID(id).Delete(&Announcement{})
-	return err
+func DelAnnouncement(id int64) error {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if _, err := sess.ID(id).Delete(&Announcement{}); err != nil {
+			return nil, err
+		}
+		if err := deleteRevisions(sess, RevisionAnnouncement, id); err != nil {
+			return nil, err
+		}
+		if err := deleteAttachmentOwners(sess, "announcement_id", id); err != nil {
+			return nil, err
+		}
+		return nil, deletePolls(sess, "announcement_id", id)
+	})
+	if err != nil {
+		return err
+	}
+	searchRemove(SearchAnnouncement, id)
+	return nil
 }
 
 // UpdateAnnouncementCols updates an announcement in the database including the
 // specified columns, even if the fields are empty.
 func UpdateAnnouncementCols(a *Announcement, cols ...string) error {
 	_, err := engine.ID(a.AnnouncementID).Cols(cols...).Update(a)
+	if err == nil {
+		searchRefresh(SearchAnnouncement, a.AnnouncementID)
+	}
 	return err
 }
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"xorm.io/xorm"
+)
+
+// Assignment assigns a ticket to a class representative, who is identified by
+// their email address.
+type Assignment struct {
+	AssignmentID int64  `xorm:"pk autoincr"`
+	TicketID     int64  `xorm:"notnull unique(assignment)"`
+	RepEmail     string `xorm:"varchar(255) notnull unique(assignment) index"`
+	CreatedUnix  int64  `xorm:"created"`
+}
+
+// GetAssignees returns the email addresses of the class representatives
+// assigned to a ticket.
+func GetAssignees(ticketID int64) (emails []string) {
+	var assignments []Assignment
+	engine.Where("ticket_id = ?", ticketID).Asc("assignment_id").Find(&assignments)
+	for _, a := range assignments {
+		emails = append(emails, a.RepEmail)
+	}
+	return
+}
+
+// AssignTicket replaces the class representatives assigned to a ticket.
+func AssignTicket(ticketID int64, emails []string) error {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if _, err := sess.Where("ticket_id = ?", ticketID).Delete(new(Assignment)); err != nil {
+			return nil, err
+		}
+		has := make(map[string]bool)
+		for _, e := range emails {
+			if has[e] {
+				continue
+			}
+			has[e] = true
+			if _, err := sess.Insert(&Assignment{TicketID: ticketID, RepEmail: e}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// Attachment is a file uploaded with a ticket or announcement. Files are
+// stored once per content, identified by the SHA-256 hash of their content.
+type Attachment struct {
+	AttachmentID int64  `xorm:"pk autoincr"`
+	Hash         string `xorm:"varchar(64) notnull unique"`
+	ContentType  string `xorm:"varchar(100) notnull"`
+	Size         int64  `xorm:"notnull"`
+	WasOwned     bool   `xorm:"notnull default false"` // WasOwned is whether the attachment was ever uploaded with a post.
+	CreatedUnix  int64  `xorm:"created"`
+}
+
+// AttachmentOwner records a ticket or announcement which an attachment was
+// uploaded with. An attachment is only served while one of its owners can be
+// seen, and is deleted with the last of them.
+type AttachmentOwner struct {
+	OwnerID        int64  `xorm:"pk autoincr"`
+	Hash           string `xorm:"varchar(64) notnull unique(attachment_owner)"`
+	TicketID       int64  `xorm:"notnull default 0 unique(attachment_owner) index"`
+	AnnouncementID int64  `xorm:"notnull default 0 unique(attachment_owner) index"`
+}
+
+// attachmentGracePeriod is how long an attachment which never had an owner is
+// kept, as files are saved before the post they are uploaded with.
+const attachmentGracePeriod = int64(time.Hour / time.Second)
+
+// attachmentLink matches the links to attachments in posts.
+var attachmentLink = regexp.MustCompile(`/attachments/([0-9a-f]{64})`)
+
+// AddAttachment records an uploaded file. If a file with the same content was
+// uploaded before, the existing attachment is returned instead.
+func AddAttachment(a *Attachment) (*Attachment, error) {
+	existing, err := GetAttachment(a.Hash)
+	if err == nil {
+		return existing, nil
+	}
+	if _, err = engine.Insert(a); err != nil {
+		// Another upload of the same file may have been recorded meanwhile.
+		if existing, err2 := GetAttachment(a.Hash); err2 == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetAttachment fetches an attachment by the hash of its content.
+func GetAttachment(hash string) (*Attachment, error) {
+	a := new(Attachment)
+	has, err := engine.Where("hash = ?", hash).Get(a)
+	if err != nil {
+		return a, err
+	} else if !has {
+		return a, errors.New("Attachment does not exist")
+	}
+	return a, nil
+}
+
+// AddAttachmentOwners records the ticket or announcement which attachments were
+// uploaded with.
+func AddAttachmentOwners(hashes []string, ticketID, announcementID int64) error {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		return nil, addAttachmentOwners(sess, hashes, ticketID, announcementID)
+	})
+	return err
+}
+
+func addAttachmentOwners(sess *xorm.Session, hashes []string, ticketID, announcementID int64) error {
+	for _, hash := range hashes {
+		has, err := sess.Where("hash = ? AND ticket_id = ? AND announcement_id = ?", hash, ticketID, announcementID).
+			Exist(new(AttachmentOwner))
+		if err != nil {
+			return err
+		}
+		if !has {
+			o := &AttachmentOwner{Hash: hash, TicketID: ticketID, AnnouncementID: announcementID}
+			if _, err = sess.Insert(o); err != nil {
+				return err
+			}
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	_, err := sess.Table(new(Attachment)).In("hash", hashes).Update(map[string]interface{}{"was_owned": true})
+	return err
+}
+
+// GetAttachmentOwners returns the tickets and announcements an attachment was
+// uploaded with.
+func GetAttachmentOwners(hash string) (owners []AttachmentOwner) {
+	engine.Where("hash = ?", hash).Find(&owners)
+	return
+}
+
+// deleteAttachmentOwners stops a deleted ticket or announcement owning its
+// attachments.
+func deleteAttachmentOwners(sess *xorm.Session, column string, id int64) error {
+	_, err := sess.Where(column+" = ?", id).Delete(new(AttachmentOwner))
+	return err
+}
+
+// DeleteUnusedAttachments deletes the attachments which no longer have an
+// owner, and returns their hashes so their files can be removed. Attachments
+// which never had an owner are kept for an hour, as their post may not be
+// added yet.
+func DeleteUnusedAttachments() ([]string, error) {
+	var unused []Attachment
+	err := engine.Where("was_owned = ? OR created_unix < ?", true, time.Now().Unix()-attachmentGracePeriod).
+		And("hash NOT IN (SELECT hash FROM attachment_owner)").Find(&unused)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(unused))
+	for _, a := range unused {
+		// Deleted one by one, so an attachment uploaded again meanwhile isn't.
+		n, err := engine.Where("attachment_id = ?", a.AttachmentID).
+			And("hash NOT IN (SELECT hash FROM attachment_owner)").Delete(new(Attachment))
+		if err != nil {
+			return hashes, err
+		}
+		if n > 0 {
+			hashes = append(hashes, a.Hash)
+		}
+	}
+	return hashes, nil
+}
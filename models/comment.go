@@ -3,29 +3,76 @@ package models
 import (
 	"errors"
 	"html/template"
+
+	"xorm.io/xorm"
 )
 
+// MaxCommentDepth is how deeply replies to comments can be nested. Top-level
+// comments have a depth of zero.
+const MaxCommentDepth = 4
+
 // Comment represents an anonymous comment to a ticket.
 type Comment struct {
 	CommentID     int64  `xorm:"pk autoincr"`
 	TicketID      int64  `xorm:"notnull"`
+	ParentID      int64  `xorm:"notnull default 0 index"` // ParentID is the comment this replies to, if not zero.
+	Depth         int    `xorm:"notnull default 0"`
 	PosterID      string `xorm:"notnull"`
 	IsAdmin       bool
+	IsDeleted     bool          `xorm:"notnull default false"` // IsDeleted marks a tombstone kept for its replies.
+	IsPending     bool          `xorm:"notnull default false"` // IsPending hides the comment until a class representative approves it.
+	PendingReason string        // PendingReason is why the comment is waiting for moderation.
 	Text          string        `xorm:"notnull"`
 	FormattedText template.HTML `xorm:"-" json:"-"`
+	IsPoster      bool          `xorm:"-"` // IsPoster marks a comment by the student who posted the ticket.
 	CreatedUnix   int64         `xorm:"created"`
 	UpdatedUnix   int64         `xorm:"updated"`
+	Replies       []*Comment    `xorm:"-"`
+}
+
+// ReplyTo makes the comment a reply to another comment. Replies to comments
+// at MaxCommentDepth are attached to the parent's own parent instead, so that
+// threads never nest deeper than the limit.
+func (c *Comment) ReplyTo(parent *Comment) {
+	if parent.Depth >= MaxCommentDepth {
+		c.ParentID, c.Depth = parent.ParentID, parent.Depth
+	} else {
+		c.ParentID, c.Depth = parent.CommentID, parent.Depth+1
+	}
+}
+
+// ThreadComments arranges comments into threads, returning the top-level
+// comments with their replies filled in. Comments keep their relative order.
+func ThreadComments(comments []Comment) (roots []*Comment) {
+	byID := make(map[int64]*Comment, len(comments))
+	for i := range comments {
+		comments[i].Replies = nil
+		byID[comments[i].CommentID] = &comments[i]
+	}
+	for i := range comments {
+		c := &comments[i]
+		if parent, ok := byID[c.ParentID]; ok && c.ParentID != 0 {
+			parent.Replies = append(parent.Replies, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+	return
 }
 
 // AddComment adds a new Comment to the database.
 func AddComment(c *Comment) (err error) {
-	_, err = engine.Insert(c)
+	if _, err = engine.Insert(c); err == nil {
+		searchRefresh(SearchComment, c.CommentID)
+	}
 	return err
 }
 
 // UpdateComment updates a comment in the database.
 func UpdateComment(c *Comment) (err error) {
-	_, err = engine.ID(c.CommentID).Update(c)
+	if _, err = engine.ID(c.CommentID).Update(c); err == nil {
+		searchRefresh(SearchComment, c.CommentID)
+	}
 	return
 }
 
@@ -42,8 +89,69 @@ func GetComment(id int64) (*Comment, error) {
 	return c, nil
 }
 
-// DeleteComment deletes a comment from the database.
-func DeleteComment(id int64) (err error) {
-	_, err = engine.ID(id).Delete(&Comment{})
+// DeleteComment deletes a comment from the database. A comment with replies is
+// replaced by a tombstone so the thread stays intact, and tombstones which are
+// left without replies are deleted along with it.
+func DeleteComment(id int64) error {
+	var removed []int64
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		for id != 0 {
+			c := new(Comment)
+			has, err := sess.ID(id).Get(c)
+			if err != nil || !has {
+				return nil, err
+			}
+			if len(removed) > 0 && !c.IsDeleted {
+				break // a parent which wasn't deleted is kept
+			}
+
+			replies, err := sess.Where("parent_id = ?", id).Count(new(Comment))
+			if err != nil {
+				return nil, err
+			}
+			if replies > 0 {
+				if len(removed) == 0 {
+					_, err = sess.ID(id).Cols("poster_id", "is_admin", "is_deleted", "text").
+						Update(&Comment{IsDeleted: true})
+					removed = append(removed, id)
+				}
+				return nil, err
+			}
+
+			if _, err = sess.ID(id).Delete(new(Comment)); err != nil {
+				return nil, err
+			}
+			removed = append(removed, id)
+			id = c.ParentID
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range removed {
+		searchRemove(SearchComment, id)
+	}
+	// A deleted official answer no longer answers its ticket.
+	if len(removed) > 0 {
+		_, err = engine.In("answer_id", removed).Cols("answer_id").NoAutoTime().Update(new(Ticket))
+	}
+	return err
+}
+
+// CountComments returns the number of comments on a ticket, not counting
+// tombstones or comments waiting for moderation.
+func CountComments(ticketID int64) int64 {
+	total, _ := engine.Where("ticket_id = ? AND is_deleted = ? AND is_pending = ?", ticketID, false, false).
+		Count(new(Comment))
+	return total
+}
+
+// GetCommentsRange fetches at most limit comments of a ticket starting from
+// start, oldest first, leaving out tombstones and comments waiting for
+// moderation.
+func GetCommentsRange(ticketID int64, start, limit int) (comments []Comment) {
+	engine.Where("ticket_id = ? AND is_deleted = ? AND is_pending = ?", ticketID, false, false).Asc("created_unix").
+		Limit(limit, start).Find(&comments)
 	return
 }
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// Escalation records a ticket being emailed to the lecturers of its course.
+type Escalation struct {
+	EscalationID int64  `xorm:"pk autoincr"`
+	TicketID     int64  `xorm:"notnull index"`
+	Admin        string // Admin is the name of the class representative who escalated the ticket.
+	Recipients   string `xorm:"text"` // Recipients are the names of the lecturers emailed.
+	Note         string `xorm:"text"`
+	CreatedUnix  int64  `xorm:"created"`
+}
+
+// AddEscalation records an escalation and marks the ticket as escalated.
+func AddEscalation(t *Ticket, e *Escalation) error {
+	now := time.Now().Unix()
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		e.TicketID = t.TicketID
+		if _, err := sess.Insert(e); err != nil {
+			return nil, err
+		}
+		_, err := sess.Exec("UPDATE ticket SET escalated_unix = ? WHERE ticket_id = ?", now, t.TicketID)
+		return nil, err
+	})
+	if err == nil {
+		t.EscalatedUnix = now
+	}
+	return err
+}
+
+// GetEscalations fetches the escalations of a ticket, oldest first.
+func GetEscalations(ticketID int64) (escalations []Escalation) {
+	engine.Where("ticket_id = ?", ticketID).Asc("escalation_id").Find(&escalations)
+	return
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// Follower is someone who gets emails about updates to a ticket. Their email
+// address is only stored hashed, to find duplicates, and encrypted, to send
+// the emails.
+type Follower struct {
+	FollowerID     int64  `xorm:"pk autoincr"`
+	TicketID       int64  `xorm:"notnull unique(follower)"`
+	EmailHash      string `xorm:"varchar(64) notnull unique(follower)"`
+	EncryptedEmail string `xorm:"text notnull"`
+	Token          string `xorm:"varchar(64) notnull unique"` // Token identifies the follower in confirmation and unsubscribe links.
+	IsConfirmed    bool   `xorm:"notnull default false"`
+	EmailedUnix    int64  `xorm:"notnull default 0"` // EmailedUnix is when the confirmation was last emailed.
+	CreatedUnix    int64  `xorm:"created"`
+}
+
+// AddFollower adds an unconfirmed follower to a ticket. If the email already
+// follows the ticket, the existing follower is returned instead.
+func AddFollower(f *Follower) (*Follower, error) {
+	existing := new(Follower)
+	has, err := engine.Where("ticket_id = ? AND email_hash = ?", f.TicketID, f.EmailHash).Get(existing)
+	if err != nil {
+		return nil, err
+	} else if has {
+		return existing, nil
+	}
+	f.IsConfirmed = false
+	if _, err = engine.Insert(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetFollowerByToken fetches a follower by the token in their links.
+func GetFollowerByToken(token string) (*Follower, error) {
+	f := new(Follower)
+	if token == "" {
+		return f, errors.New("Follower does not exist")
+	}
+	has, err := engine.Where("token = ?", token).Get(f)
+	if err != nil {
+		return f, err
+	} else if !has {
+		return f, errors.New("Follower does not exist")
+	}
+	return f, nil
+}
+
+// ConfirmFollower starts sending updates to a follower.
+func ConfirmFollower(f *Follower) error {
+	f.IsConfirmed = true
+	_, err := engine.ID(f.FollowerID).Cols("is_confirmed").Update(f)
+	return err
+}
+
+// MarkFollowerEmailed records that the confirmation is emailed to a follower,
+// unless it was already emailed in the last cooldown seconds. It returns
+// whether the confirmation may be emailed.
+func MarkFollowerEmailed(f *Follower, cooldown int64) (bool, error) {
+	now := time.Now().Unix()
+	n, err := engine.Table(new(Follower)).Where("follower_id = ? AND emailed_unix <= ?", f.FollowerID, now-cooldown).
+		Update(map[string]interface{}{"emailed_unix": now})
+	if err != nil {
+		return false, err
+	}
+	f.EmailedUnix = now
+	return n > 0, nil
+}
+
+// DeleteFollower stops sending updates to a follower.
+func DeleteFollower(id int64) error {
+	_, err := engine.ID(id).Delete(new(Follower))
+	return err
+}
+
+// GetConfirmedFollowers returns the followers of a ticket who confirmed their
+// email address.
+func GetConfirmedFollowers(ticketID int64) (followers []Follower) {
+	engine.Where("ticket_id = ? AND is_confirmed = ?", ticketID, true).Find(&followers)
+	return
+}
+
+// deleteFollowers deletes all the followers of a ticket.
+func deleteFollowers(sess *xorm.Session, ticketID int64) error {
+	_, err := sess.Where("ticket_id = ?", ticketID).Delete(new(Follower))
+	return err
+}
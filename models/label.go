@@ -0,0 +1,156 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"xorm.io/xorm"
+)
+
+// Label is a tag defined by the class representatives which can be put on
+// tickets of any category, such as "assessment" or "timetabling".
+type Label struct {
+	LabelID     int64  `xorm:"pk autoincr"`
+	Name        string `xorm:"varchar(50) notnull unique"`
+	Colour      string `xorm:"varchar(7) notnull"` // Colour is a hex colour such as #1e90ff.
+	CreatedUnix int64  `xorm:"created"`
+}
+
+// TicketLabel puts a label on a ticket.
+type TicketLabel struct {
+	TicketLabelID int64 `xorm:"pk autoincr"`
+	TicketID      int64 `xorm:"notnull unique(ticket_label)"`
+	LabelID       int64 `xorm:"notnull unique(ticket_label) index"`
+}
+
+// TextColour returns a text colour which is readable on the label's colour.
+func (l Label) TextColour() string {
+	if len(l.Colour) != 7 {
+		return "#fff"
+	}
+	rgb, err := strconv.ParseUint(l.Colour[1:], 16, 32)
+	if err != nil {
+		return "#fff"
+	}
+	r, g, b := rgb>>16&0xff, rgb>>8&0xff, rgb&0xff
+	if r*299+g*587+b*114 > 128000 {
+		return "#000"
+	}
+	return "#fff"
+}
+
+// AddLabel inserts a new label into the database.
+func AddLabel(l *Label) (err error) {
+	_, err = engine.Insert(l)
+	return
+}
+
+// UpdateLabel updates the name and colour of a label.
+func UpdateLabel(l *Label) (err error) {
+	_, err = engine.ID(l.LabelID).Cols("name", "colour").Update(l)
+	return
+}
+
+// DeleteLabel deletes a label, removing it from every ticket.
+func DeleteLabel(id int64) error {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if _, err := sess.Where("label_id = ?", id).Delete(new(TicketLabel)); err != nil {
+			return nil, err
+		}
+		_, err := sess.ID(id).Delete(new(Label))
+		return nil, err
+	})
+	return err
+}
+
+// GetLabel fetches a label based on the LabelID.
+func GetLabel(id int64) (*Label, error) {
+	l := new(Label)
+	has, err := engine.ID(id).Get(l)
+	if err != nil {
+		return l, err
+	} else if !has {
+		return l, errors.New("Label does not exist")
+	}
+	return l, nil
+}
+
+// GetLabelByName fetches a label based on its name.
+func GetLabelByName(name string) (*Label, error) {
+	l := new(Label)
+	has, err := engine.Where("name = ?", name).Get(l)
+	if err != nil {
+		return l, err
+	} else if !has {
+		return l, errors.New("Label does not exist")
+	}
+	return l, nil
+}
+
+// GetLabels fetches all the labels, sorted by name.
+func GetLabels() (labels []Label) {
+	engine.Asc("name").Find(&labels)
+	return
+}
+
+// GetTicketLabels fetches the labels of a ticket, sorted by name.
+func GetTicketLabels(ticketID int64) (labels []Label) {
+	engine.Where("label_id IN (SELECT label_id FROM ticket_label WHERE ticket_id = ?)", ticketID).
+		Asc("name").Find(&labels)
+	return
+}
+
+// SetTicketLabels replaces the labels of a ticket.
+func SetTicketLabels(ticketID int64, labelIDs []int64) error {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if _, err := sess.Where("ticket_id = ?", ticketID).Delete(new(TicketLabel)); err != nil {
+			return nil, err
+		}
+		has := make(map[int64]bool)
+		for _, id := range labelIDs {
+			if has[id] {
+				continue
+			}
+			has[id] = true
+			if _, err := sess.Insert(&TicketLabel{TicketID: ticketID, LabelID: id}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// LoadLabels fills in the labels of each ticket with a single query.
+func LoadLabels(tickets []Ticket) error {
+	if len(tickets) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(tickets))
+	for i, t := range tickets {
+		ids[i] = t.TicketID
+	}
+
+	var links []TicketLabel
+	if err := engine.In("ticket_id", ids).Find(&links); err != nil {
+		return err
+	}
+	labels := make(map[int64]Label)
+	for _, l := range GetLabels() {
+		labels[l.LabelID] = l
+	}
+
+	byTicket := make(map[int64][]Label)
+	for _, link := range links {
+		if l, ok := labels[link.LabelID]; ok {
+			byTicket[link.TicketID] = append(byTicket[link.TicketID], l)
+		}
+	}
+	for i := range tickets {
+		l := byTicket[tickets[i].TicketID]
+		sort.Slice(l, func(a, b int) bool { return l[a].Name < l[b].Name })
+		tickets[i].Labels = l
+	}
+	return nil
+}
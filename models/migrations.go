@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"xorm.io/xorm"
+)
+
+// Version records how many migrations have been applied to the database.
+type Version struct {
+	VersionID int64 `xorm:"pk"`
+	Version   int
+}
+
+// migration upgrades the data of a database created by an older version of
+// the platform. Migrations run after the schema is synced, and must do nothing
+// on a new database.
+type migration struct {
+	description string
+	migrate     func(*xorm.Engine) error
+}
+
+// migrations is the list of migrations in the order they are applied. New
+// migrations must only ever be appended.
+var migrations = []migration{
+	{"Replace the resolved flag of tickets with a status", migrateResolvedToStatus},
+	{"Move the voters of tickets to the vote table", migrateVotersToTable},
+	{"Record the posts attachments were uploaded with", migrateAttachmentOwners},
+}
+
+// hasColumn checks whether a table has a column, including columns which are
+// no longer mapped to a field.
+func hasColumn(x *xorm.Engine, table, column string) (bool, error) {
+	return x.Dialect().IsColumnExist(x.DB(), context.Background(), table, column)
+}
+
+// migrate applies the migrations which haven't been applied yet.
+func migrate() error {
+	v := &Version{VersionID: 1}
+	has, err := engine.Get(v)
+	if err != nil {
+		return err
+	} else if !has {
+		if _, err = engine.Insert(v); err != nil {
+			return err
+		}
+	}
+
+	for v.Version < len(migrations) {
+		m := migrations[v.Version]
+		log.Printf("Migration %d: %s\n", v.Version+1, m.description)
+		if err = m.migrate(engine); err != nil {
+			return err
+		}
+		v.Version++
+		if _, err = engine.ID(v.VersionID).Cols("version").Update(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateResolvedToStatus(x *xorm.Engine) error {
+	has, err := hasColumn(x, "ticket", "is_resolved")
+	if err != nil || !has {
+		return err
+	}
+	_, err = x.Exec("UPDATE ticket SET status = ? WHERE is_resolved = ?", StatusResolved, true)
+	return err
+}
+
+func migrateVotersToTable(x *xorm.Engine) error {
+	has, err := hasColumn(x, "ticket", "voters")
+	if err != nil || !has {
+		return err
+	}
+
+	rows, err := x.QueryString("SELECT ticket_id, voters FROM ticket WHERE voters IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	_, err = x.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		for _, row := range rows {
+			id, err := strconv.ParseInt(row["ticket_id"], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			var voters []string
+			if row["voters"] != "" {
+				if err = json.Unmarshal([]byte(row["voters"]), &voters); err != nil {
+					return nil, err
+				}
+			}
+
+			has := make(map[string]bool)
+			for _, v := range voters {
+				if has[v] {
+					continue
+				}
+				has[v] = true
+				if _, err = sess.Insert(&Vote{TicketID: id, VoterHash: v}); err != nil {
+					return nil, err
+				}
+			}
+			_, err = sess.Exec("UPDATE ticket SET vote_count = ?, voters = NULL WHERE ticket_id = ?", len(has), id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func migrateAttachmentOwners(x *xorm.Engine) error {
+	var attachments []Attachment
+	if err := x.Cols("hash").Find(&attachments); err != nil || len(attachments) == 0 {
+		return err
+	}
+	uploaded := make(map[string]bool, len(attachments))
+	for _, a := range attachments {
+		uploaded[a.Hash] = true
+	}
+	// linked returns the attachments linked to in a post.
+	linked := func(description string) (hashes []string) {
+		for _, m := range attachmentLink.FindAllStringSubmatch(description, -1) {
+			if uploaded[m[1]] {
+				hashes = append(hashes, m[1])
+			}
+		}
+		return
+	}
+
+	var tickets []Ticket
+	if err := x.Cols("ticket_id", "description").Find(&tickets); err != nil {
+		return err
+	}
+	var announcements []Announcement
+	if err := x.Cols("announcement_id", "description").Find(&announcements); err != nil {
+		return err
+	}
+	_, err := x.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		for _, t := range tickets {
+			if err := addAttachmentOwners(sess, linked(t.Description), t.TicketID, 0); err != nil {
+				return nil, err
+			}
+		}
+		for _, a := range announcements {
+			if err := addAttachmentOwners(sess, linked(a.Description), 0, a.AnnouncementID); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
@@ -23,6 +23,22 @@ func init() {
 		new(Moderation),
 		new(Ticket),
 		new(Comment),
+		new(StatusChange),
+		new(Version),
+		new(Vote),
+		new(Assignment),
+		new(Follower),
+		new(Attachment),
+		new(AttachmentOwner),
+		new(Label),
+		new(TicketLabel),
+		new(Escalation),
+		new(Revision),
+		new(RateLimitHit),
+		new(Report),
+		new(Poll),
+		new(PollBallot),
+		new(Pseudonym),
 	)
 }
 
@@ -56,5 +72,12 @@ func SetupEngine() *xorm.Engine {
 		log.Fatal("Unable to sync schema! ", err)
 	}
 
+	if err = migrate(); err != nil {
+		log.Fatal("Unable to migrate the database! ", err)
+	}
+
+	setupSearch()
+	similarTickets.build()
+
 	return engine
 }
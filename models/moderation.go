@@ -2,6 +2,10 @@ package models
 
 import "errors"
 
+// SystemActor is the admin logged for actions done automatically by the
+// platform.
+const SystemActor = "Platform (automatic)"
+
 // Moderation represents an moderations
 type Moderation struct {
 	ModerationID         int64 `xorm:"pk autoincr"`
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// PollType is how students vote in a poll.
+type PollType string
+
+const (
+	// PollSingle lets voters choose one option.
+	PollSingle PollType = "single"
+	// PollMultiple lets voters choose any number of options.
+	PollMultiple PollType = "multiple"
+	// PollRanked lets voters rank options in order of preference.
+	PollRanked PollType = "ranked"
+)
+
+// PollTypes are all the types of poll.
+var PollTypes = []PollType{PollSingle, PollMultiple, PollRanked}
+
+// IsValid checks whether the type is one of PollTypes.
+func (t PollType) IsValid() bool {
+	for _, v := range PollTypes {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the human readable name of the type.
+func (t PollType) Name() string {
+	switch t {
+	case PollSingle:
+		return "Single choice"
+	case PollMultiple:
+		return "Multiple choice"
+	case PollRanked:
+		return "Ranked"
+	}
+	return string(t)
+}
+
+// Poll represents a question class representatives ask students to vote on,
+// attached to a ticket or an announcement.
+type Poll struct {
+	PollID         int64    `xorm:"pk autoincr"`
+	TicketID       int64    `xorm:"notnull default 0 index"` // TicketID is the ticket the poll is on, if not zero.
+	AnnouncementID int64    `xorm:"notnull default 0 index"` // AnnouncementID is the announcement the poll is on, if not zero.
+	Question       string   `xorm:"text"`
+	Type           PollType `xorm:"varchar(16) notnull"`
+	Options        []string `xorm:"text"`
+	ClosesUnix     int64    `xorm:"notnull default 0"`     // ClosesUnix is when voting ends, if not zero.
+	HideResults    bool     `xorm:"notnull default false"` // HideResults hides the results until the poll closes.
+	Admin          string   // Admin is the name of the class representative who made the poll.
+	CreatedUnix    int64    `xorm:"created"`
+}
+
+// PollBallot represents the vote of an anonymous voter in a poll.
+type PollBallot struct {
+	BallotID    int64  `xorm:"pk autoincr"`
+	PollID      int64  `xorm:"notnull unique(ballot)"`
+	VoterHash   string `xorm:"varchar(64) notnull unique(ballot)"`
+	Choices     []int  `xorm:"text"` // Choices are the indexes of the options, most preferred first in ranked polls.
+	CreatedUnix int64  `xorm:"created"`
+}
+
+// IsClosed checks whether voting in the poll has ended.
+func (p Poll) IsClosed() bool {
+	return p.ClosesUnix != 0 && p.ClosesUnix <= time.Now().Unix()
+}
+
+// AddPoll inserts a new poll into the database.
+func AddPoll(p *Poll) error {
+	_, err := engine.Insert(p)
+	return err
+}
+
+// GetPoll fetches a poll by its ID.
+func GetPoll(id int64) (*Poll, error) {
+	p := new(Poll)
+	has, err := engine.ID(id).Get(p)
+	if err != nil {
+		return p, err
+	} else if !has {
+		return p, errors.New("Poll does not exist")
+	}
+	return p, nil
+}
+
+// GetTicketPolls fetches the polls of a ticket, oldest first.
+func GetTicketPolls(ticketID int64) (polls []Poll) {
+	engine.Where("ticket_id = ?", ticketID).Asc("poll_id").Find(&polls)
+	return
+}
+
+// GetAnnouncementPolls fetches the polls of an announcement, oldest first.
+func GetAnnouncementPolls(announcementID int64) (polls []Poll) {
+	engine.Where("announcement_id = ?", announcementID).Asc("poll_id").Find(&polls)
+	return
+}
+
+// ClosePoll ends voting in a poll now.
+func ClosePoll(p *Poll) error {
+	p.ClosesUnix = time.Now().Unix()
+	_, err := engine.ID(p.PollID).Cols("closes_unix").Update(p)
+	return err
+}
+
+// DeletePoll deletes a poll and its ballots.
+func DeletePoll(id int64) error {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if _, err := sess.Where("poll_id = ?", id).Delete(new(PollBallot)); err != nil {
+			return nil, err
+		}
+		_, err := sess.ID(id).Delete(new(Poll))
+		return nil, err
+	})
+	return err
+}
+
+// deletePolls deletes the polls of a ticket or announcement, matched by the
+// column, and their ballots.
+func deletePolls(sess *xorm.Session, column string, id int64) error {
+	_, err := sess.Where("poll_id IN (SELECT poll_id FROM poll WHERE "+column+" = ?)", id).Delete(new(PollBallot))
+	if err != nil {
+		return err
+	}
+	_, err = sess.Where(column+" = ?", id).Delete(new(Poll))
+	return err
+}
+
+// HasVotedInPoll checks whether a voter has voted in a poll.
+func HasVotedInPoll(pollID int64, voterHash string) bool {
+	has, _ := engine.Where("poll_id = ? AND voter_hash = ?", pollID, voterHash).Exist(new(PollBallot))
+	return has
+}
+
+// AddBallot records the vote of a voter in a poll, and returns whether it was
+// counted. Voters who have already voted in the poll aren't counted again.
+func AddBallot(b *PollBallot) (bool, error) {
+	if _, err := engine.Insert(b); err != nil {
+		// The insert fails on the unique constraint if the voter has already
+		// voted, even when racing another request.
+		if HasVotedInPoll(b.PollID, b.VoterHash) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetBallots fetches every ballot of a poll.
+func GetBallots(pollID int64) (ballots []PollBallot) {
+	engine.Where("poll_id = ?", pollID).Find(&ballots)
+	return
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/namegen"
+	"xorm.io/xorm"
+)
+
+// pseudonymAttempts is how many names derived from a voter are tried before
+// numbering the name instead, when the names are taken in a ticket.
+const pseudonymAttempts = 20
+
+// Pseudonym is the name a student posts under on a ticket. Names are derived
+// from the voter hash and the ticket, so a student keeps the same name within
+// a ticket but can't be linked across tickets, and no two students share a
+// name within a ticket.
+type Pseudonym struct {
+	PseudonymID int64  `xorm:"pk autoincr"`
+	TicketID    int64  `xorm:"notnull unique(pseudonym_voter) unique(pseudonym_name)"`
+	VoterKey    string `xorm:"varchar(64) notnull unique(pseudonym_voter)"` // VoterKey is the voter hash keyed to the ticket.
+	Name        string `xorm:"varchar(100) notnull unique(pseudonym_name)"`
+	IsPoster    bool   `xorm:"notnull default false"` // IsPoster marks the student who posted the ticket.
+	CreatedUnix int64  `xorm:"created"`
+}
+
+// pseudonymKey derives the key of a voter in a ticket, which can't be linked
+// to their key in other tickets without the pepper.
+func pseudonymKey(ticketID int64, voterHash string) []byte {
+	mac := hmac.New(sha256.New, []byte(config.Config.VoterPepper))
+	fmt.Fprintf(mac, "%s:%d", voterHash, ticketID)
+	return mac.Sum(nil)
+}
+
+// pseudonymName returns the name derived from a voter's key on the given
+// attempt, each attempt giving another name.
+func pseudonymName(key []byte, attempt int) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d", attempt)
+	return namegen.GetNameFromSeed(binary.BigEndian.Uint64(mac.Sum(nil)))
+}
+
+// GetPseudonym returns the name of a voter in a ticket, picking a name not
+// taken by anyone else in the ticket if they haven't posted in it before.
+func GetPseudonym(ticketID int64, voterHash string) (string, error) {
+	return getPseudonym(ticketID, voterHash, false)
+}
+
+// SetTicketPoster gives the poster of a ticket their name in it, marking them
+// as its original poster.
+func SetTicketPoster(ticketID int64, voterHash string) error {
+	_, err := getPseudonym(ticketID, voterHash, true)
+	return err
+}
+
+func getPseudonym(ticketID int64, voterHash string, isPoster bool) (name string, err error) {
+	key := pseudonymKey(ticketID, voterHash)
+	p := Pseudonym{TicketID: ticketID, VoterKey: fmt.Sprintf("%x", key), IsPoster: isPoster}
+
+	// Inserting fails if somebody else took the name in the meantime, in
+	// which case another name is picked.
+	for retry := 0; retry < 3; retry++ {
+		var existing Pseudonym
+		has, err := engine.Where("ticket_id = ? AND voter_key = ?", ticketID, p.VoterKey).Get(&existing)
+		if err != nil {
+			return "", err
+		} else if has {
+			return existing.Name, nil
+		}
+
+		taken, err := takenNames(engine, ticketID)
+		if err != nil {
+			return "", err
+		}
+
+		p.Name = ""
+		for attempt := 0; attempt < pseudonymAttempts && p.Name == ""; attempt++ {
+			if n := pseudonymName(key, attempt); !taken[n] {
+				p.Name = n
+			}
+		}
+		for i := 2; p.Name == ""; i++ {
+			if n := fmt.Sprintf("%s %d", pseudonymName(key, 0), i); !taken[n] {
+				p.Name = n
+			}
+		}
+
+		if _, err = engine.Insert(&p); err == nil {
+			return p.Name, nil
+		}
+	}
+	return "", fmt.Errorf("failed to pick a pseudonym in ticket %d", ticketID)
+}
+
+// takenNames returns the names students post under in a ticket. Besides the
+// pseudonyms, this includes the names on comments posted before students had
+// pseudonyms.
+func takenNames(x xorm.Interface, ticketID int64) (map[string]bool, error) {
+	var names, posters []string
+	if err := x.Table(new(Pseudonym)).Where("ticket_id = ?", ticketID).Cols("name").Find(&names); err != nil {
+		return nil, err
+	}
+	err := x.Table(new(Comment)).Where("ticket_id = ? AND is_admin = ?", ticketID, false).
+		Distinct("poster_id").Find(&posters)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool, len(names)+len(posters))
+	for _, n := range append(names, posters...) {
+		taken[n] = true
+	}
+	return taken, nil
+}
+
+// mergePseudonyms moves the names students post under in a duplicate ticket to
+// its canonical ticket, numbering the names taken in the canonical ticket so
+// students in the two tickets aren't confused, nor marked as its original
+// poster. It must be called before the comments are moved.
+func mergePseudonyms(sess *xorm.Session, dupID, canonicalID int64) error {
+	taken, err := takenNames(sess, canonicalID)
+	if err != nil {
+		return err
+	}
+	moving, err := takenNames(sess, dupID)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(moving))
+	for n := range moving {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		// A name isn't numbered into another name being moved either.
+		renamed := n
+		for i := 2; taken[renamed] || renamed != n && moving[renamed]; i++ {
+			renamed = fmt.Sprintf("%s %d", n, i)
+		}
+		taken[renamed] = true
+		if renamed == n {
+			continue
+		}
+		_, err = sess.Table(new(Comment)).Where("ticket_id = ? AND is_admin = ? AND poster_id = ?", dupID, false, n).
+			Update(map[string]interface{}{"poster_id": renamed})
+		if err != nil {
+			return err
+		}
+		_, err = sess.Table(new(Pseudonym)).Where("ticket_id = ? AND name = ?", dupID, n).
+			Update(map[string]interface{}{"name": renamed})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The keys of the moved names don't match the keys of the same voters in
+	// the canonical ticket, so they only keep the names from being reused.
+	_, err = sess.Table(new(Pseudonym)).Where("ticket_id = ?", dupID).
+		Update(map[string]interface{}{"ticket_id": canonicalID, "is_poster": false})
+	return err
+}
+
+// GetPosterPseudonym returns the name of the student who posted a ticket, or
+// an empty string if they are unknown.
+func GetPosterPseudonym(ticketID int64) string {
+	var p Pseudonym
+	if has, _ := engine.Where("ticket_id = ? AND is_poster = ?", ticketID, true).Get(&p); has {
+		return p.Name
+	}
+	return ""
+}
+
+// MarkPosterComments marks the comments of a ticket written by the student
+// who posted it.
+func MarkPosterComments(ticketID int64, comments []Comment) {
+	poster := GetPosterPseudonym(ticketID)
+	for i := range comments {
+		c := &comments[i]
+		c.IsPoster = poster != "" && !c.IsAdmin && c.PosterID == poster
+	}
+}
+
+func deletePseudonyms(sess *xorm.Session, ticketID int64) error {
+	_, err := sess.Where("ticket_id = ?", ticketID).Delete(new(Pseudonym))
+	return err
+}
@@ -0,0 +1,52 @@
+package models
+
+// GetPendingTickets fetches the tickets waiting for moderation, oldest first.
+func GetPendingTickets() (tickets []Ticket) {
+	engine.Where("is_pending = ?", true).Asc("created_unix").Find(&tickets)
+	return
+}
+
+// GetPendingComments fetches the comments waiting for moderation, oldest
+// first.
+func GetPendingComments() (comments []Comment) {
+	engine.Where("is_pending = ?", true).Asc("created_unix").Find(&comments)
+	return
+}
+
+// ApproveTicket makes a ticket waiting for moderation visible.
+func ApproveTicket(t *Ticket) error {
+	t.IsPending, t.PendingReason = false, ""
+	_, err := engine.ID(t.TicketID).Cols("is_pending", "pending_reason").NoAutoTime().Update(t)
+	return err
+}
+
+// ApproveComment makes a comment waiting for moderation visible.
+func ApproveComment(c *Comment) error {
+	c.IsPending, c.PendingReason = false, ""
+	_, err := engine.ID(c.CommentID).Cols("is_pending", "pending_reason").NoAutoTime().Update(c)
+	return err
+}
+
+// HoldTicket hides a ticket until a class representative approves it.
+func HoldTicket(t *Ticket, reason string) error {
+	t.IsPending, t.PendingReason = true, reason
+	_, err := engine.ID(t.TicketID).Cols("is_pending", "pending_reason").NoAutoTime().Update(t)
+	return err
+}
+
+// HoldComment hides a comment until a class representative approves it.
+func HoldComment(c *Comment, reason string) error {
+	c.IsPending, c.PendingReason = true, reason
+	_, err := engine.ID(c.CommentID).Cols("is_pending", "pending_reason").NoAutoTime().Update(c)
+	return err
+}
+
+// HasRecentPost checks whether a ticket or comment with exactly the text was
+// posted since the time.
+func HasRecentPost(text string, since int64) bool {
+	has, _ := engine.Where("description = ? AND created_unix >= ?", text, since).Exist(new(Ticket))
+	if !has {
+		has, _ = engine.Where("text = ? AND created_unix >= ?", text, since).Exist(new(Comment))
+	}
+	return has
+}
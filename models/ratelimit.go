@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+)
+
+// Actions which are rate limited.
+const (
+	ActionTicket  = "ticket"
+	ActionComment = "comment"
+	ActionVote    = "vote"
+	ActionFollow  = "follow"
+	ActionReport  = "report"
+)
+
+// RateLimitHit records an anonymous user doing a rate limited action, by their
+// voter hash and the random ID of their session.
+type RateLimitHit struct {
+	HitID       int64  `xorm:"pk autoincr"`
+	Action      string `xorm:"varchar(16) notnull index(rate_limit_hit)"`
+	VoterHash   string `xorm:"varchar(64) notnull"`
+	SessionID   string `xorm:"varchar(64) notnull"`
+	CreatedUnix int64  `xorm:"notnull index(rate_limit_hit)"`
+}
+
+// CheckRateLimit checks whether a user has done an action count times in the
+// last period seconds. It returns zero if they may do it, or otherwise when
+// they may do it again.
+//
+// The voter hash and the session are limited separately, so a script can't
+// get around the limit by dropping its session, while users sharing an
+// address are only limited together as they already share one upvote.
+func CheckRateLimit(action, voterHash, sessionID string, count int, period int64) (int64, error) {
+	since := time.Now().Unix() - period
+	var retry int64
+	for _, by := range []struct{ column, key string }{{"voter_hash", voterHash}, {"session_id", sessionID}} {
+		if by.key == "" {
+			continue
+		}
+		var hits []RateLimitHit
+		err := engine.Where("action = ? AND created_unix > ?", action, since).
+			And(by.column+" = ?", by.key).Asc("created_unix").Limit(count).Find(&hits)
+		if err != nil {
+			return 0, err
+		}
+		if len(hits) >= count && hits[0].CreatedUnix+period > retry {
+			retry = hits[0].CreatedUnix + period
+		}
+	}
+	return retry, nil
+}
+
+// AddRateLimitHit records a user doing an action, once it was done. Hits older
+// than the period of the action no longer count and are deleted.
+func AddRateLimitHit(action, voterHash, sessionID string, period int64) error {
+	now := time.Now().Unix()
+	if _, err := engine.Where("action = ? AND created_unix <= ?", action, now-period).
+		Delete(new(RateLimitHit)); err != nil {
+		return err
+	}
+	_, err := engine.Insert(&RateLimitHit{
+		Action:      action,
+		VoterHash:   voterHash,
+		SessionID:   sessionID,
+		CreatedUnix: now,
+	})
+	return err
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"xorm.io/xorm"
+)
+
+// ReportKind is the type of content a report is about.
+type ReportKind string
+
+const (
+	// ReportTicket is a report of a ticket.
+	ReportTicket ReportKind = "ticket"
+	// ReportComment is a report of a comment.
+	ReportComment ReportKind = "comment"
+)
+
+// Report represents a student flagging a ticket or comment as abusive.
+type Report struct {
+	ReportID    int64      `xorm:"pk autoincr"`
+	Kind        ReportKind `xorm:"varchar(16) notnull unique(report)"`
+	ItemID      int64      `xorm:"notnull unique(report)"`
+	TicketID    int64      `xorm:"notnull index"` // TicketID is the ticket reported, or the ticket of the comment.
+	VoterHash   string     `xorm:"varchar(64) notnull unique(report)"`
+	SessionID   string     `xorm:"varchar(64) notnull default ''"` // SessionID is the random ID of the reporter's session.
+	SessionUnix int64      `xorm:"notnull default 0"`              // SessionUnix is when the reporter's session started.
+	Reason      string     `xorm:"text"`
+	IsResolved  bool       `xorm:"notnull default false"` // IsResolved is whether a class representative has reviewed it.
+	CreatedUnix int64      `xorm:"created"`
+}
+
+// ReportedItem is a ticket or comment with reports which haven't been
+// reviewed.
+type ReportedItem struct {
+	Kind     ReportKind
+	ItemID   int64
+	TicketID int64
+	Count    int
+	LastUnix int64 // LastUnix is when it was last reported.
+}
+
+// AddReport records a report, and returns whether it was counted. Voters and
+// sessions which have already reported the item aren't counted again.
+func AddReport(r *Report) (bool, error) {
+	if r.SessionID != "" {
+		has, err := engine.Where("kind = ? AND item_id = ? AND session_id = ?", r.Kind, r.ItemID, r.SessionID).
+			Exist(new(Report))
+		if err != nil || has {
+			return false, err
+		}
+	}
+	if _, err := engine.Insert(r); err != nil {
+		// The insert fails on the unique constraint if the voter has already
+		// reported the item.
+		if has, _ := engine.Where("kind = ? AND item_id = ? AND voter_hash = ?", r.Kind, r.ItemID, r.VoterHash).
+			Exist(new(Report)); has {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// CountReports returns the number of reports of an item which haven't been
+// reviewed.
+func CountReports(kind ReportKind, itemID int64) int64 {
+	total, _ := engine.Where("kind = ? AND item_id = ? AND is_resolved = ?", kind, itemID, false).
+		Count(new(Report))
+	return total
+}
+
+// CountIndependentReports returns the number of reports of an item which
+// haven't been reviewed, only counting reports from sessions which had started
+// at least minSessionAge seconds before reporting, once each. As the voter
+// hash of a reporter can be made up, this stops a single person hiding an item
+// by reporting it again and again from new sessions.
+func CountIndependentReports(kind ReportKind, itemID, minSessionAge int64) int64 {
+	var reports []Report
+	engine.Where("kind = ? AND item_id = ? AND is_resolved = ?", kind, itemID, false).
+		And("session_id <> '' AND session_unix > 0 AND session_unix <= created_unix - ?", minSessionAge).
+		Cols("session_id").Find(&reports)
+	sessions := make(map[string]bool, len(reports))
+	for _, r := range reports {
+		sessions[r.SessionID] = true
+	}
+	return int64(len(sessions))
+}
+
+// GetReports fetches the reports of an item which haven't been reviewed,
+// oldest first.
+func GetReports(kind ReportKind, itemID int64) (reports []Report) {
+	engine.Where("kind = ? AND item_id = ? AND is_resolved = ?", kind, itemID, false).
+		Asc("created_unix").Find(&reports)
+	return
+}
+
+// GetReportedItems returns the items with reports which haven't been reviewed,
+// the most reported first.
+func GetReportedItems() (items []ReportedItem) {
+	engine.Table(new(Report)).
+		Select("kind, item_id, ticket_id, COUNT(*) AS count, MAX(created_unix) AS last_unix").
+		Where("is_resolved = ?", false).GroupBy("kind, item_id, ticket_id").
+		OrderBy("count DESC, last_unix DESC").Find(&items)
+	return
+}
+
+// ResolveReports marks the reports of an item as reviewed.
+func ResolveReports(kind ReportKind, itemID int64) error {
+	_, err := engine.Where("kind = ? AND item_id = ?", kind, itemID).Cols("is_resolved").
+		Update(&Report{IsResolved: true})
+	return err
+}
+
+// deleteReports deletes the reports of a ticket and its comments.
+func deleteReports(sess *xorm.Session, ticketID int64) error {
+	_, err := sess.Where("ticket_id = ?", ticketID).Delete(new(Report))
+	return err
+}
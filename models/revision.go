@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+
+	"xorm.io/xorm"
+)
+
+// RevisionKind is the type of content a revision is of.
+type RevisionKind string
+
+const (
+	// RevisionTicket is a revision of a ticket.
+	RevisionTicket RevisionKind = "ticket"
+	// RevisionAnnouncement is a revision of an announcement.
+	RevisionAnnouncement RevisionKind = "announcement"
+)
+
+// Revision is a version of a ticket or announcement, recorded whenever one is
+// posted, edited or restored.
+type Revision struct {
+	RevisionID  int64        `xorm:"pk autoincr"`
+	Kind        RevisionKind `xorm:"varchar(20) notnull index(revision_item)"`
+	ItemID      int64        `xorm:"notnull index(revision_item)"` // ItemID is the ID of the ticket or announcement.
+	Title       string       `xorm:"text"`
+	Description string       `xorm:"text"`
+	Category    string       `xorm:"text"` // Category is only used by tickets.
+	Tags        string       `xorm:"text"` // Tags is only used by announcements.
+	Admin       string       // Admin is the class representative who made the revision, or empty for the poster.
+	IsSensitive bool         `xorm:"notnull default false"` // IsSensitive hides the earlier revisions from the public.
+	CreatedUnix int64        `xorm:"created"`
+}
+
+// TicketRevision returns the current version of a ticket as a revision.
+func TicketRevision(t *Ticket) *Revision {
+	return &Revision{
+		Kind:        RevisionTicket,
+		ItemID:      t.TicketID,
+		Title:       t.Title,
+		Description: t.Description,
+		Category:    t.Category,
+		CreatedUnix: t.UpdatedUnix,
+	}
+}
+
+// AnnouncementRevision returns the current version of an announcement as a
+// revision.
+func AnnouncementRevision(a *Announcement) *Revision {
+	return &Revision{
+		Kind:        RevisionAnnouncement,
+		ItemID:      a.AnnouncementID,
+		Title:       a.Title,
+		Description: a.Description,
+		Tags:        a.Tags,
+		CreatedUnix: a.UpdatedUnix,
+	}
+}
+
+// AddRevision records a new revision. Items posted before revisions were
+// recorded have none, so the version before the change is recorded first, as
+// it was when last updated.
+func AddRevision(before, r *Revision) error {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if before != nil {
+			has, err := sess.Where("kind = ? AND item_id = ?", before.Kind, before.ItemID).Exist(new(Revision))
+			if err != nil {
+				return nil, err
+			}
+			if !has {
+				if _, err = sess.NoAutoTime().Insert(before); err != nil {
+					return nil, err
+				}
+			}
+		}
+		_, err := sess.Insert(r)
+		return nil, err
+	})
+	return err
+}
+
+// GetRevision fetches a revision based on the RevisionID.
+func GetRevision(id int64) (*Revision, error) {
+	r := new(Revision)
+	has, err := engine.ID(id).Get(r)
+	if err != nil {
+		return r, err
+	} else if !has {
+		return r, errors.New("Revision does not exist")
+	}
+	return r, nil
+}
+
+// GetRevisions fetches the revisions of a ticket or announcement, oldest
+// first.
+func GetRevisions(kind RevisionKind, itemID int64) (revisions []Revision) {
+	engine.Where("kind = ? AND item_id = ?", kind, itemID).Asc("revision_id").Find(&revisions)
+	return
+}
+
+// deleteRevisions deletes the revisions of a deleted ticket or announcement,
+// as it may have been deleted for what it said.
+func deleteRevisions(sess *xorm.Session, kind RevisionKind, itemID int64) error {
+	_, err := sess.Where("kind = ? AND item_id = ?", kind, itemID).Delete(new(Revision))
+	return err
+}
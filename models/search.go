@@ -0,0 +1,586 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/hw-cs-reps/platform/config"
+)
+
+// SearchKind is the type of content a search result refers to.
+type SearchKind int
+
+const (
+	// SearchTicket is a search result pointing to a ticket.
+	SearchTicket SearchKind = iota
+	// SearchComment is a search result pointing to a comment of a ticket.
+	SearchComment
+	// SearchAnnouncement is a search result pointing to an announcement.
+	SearchAnnouncement
+
+	searchKinds = 3
+)
+
+func (k SearchKind) String() string {
+	switch k {
+	case SearchTicket:
+		return "ticket"
+	case SearchComment:
+		return "comment"
+	case SearchAnnouncement:
+		return "announcement"
+	}
+	return "unknown"
+}
+
+// SearchResult is a single match of a search query.
+type SearchResult struct {
+	Kind     SearchKind
+	ID       int64   // ID is the ID of the ticket, comment or announcement.
+	TicketID int64   // TicketID is the ticket the result belongs to, if any.
+	Title    string  // Title is the title of the result, or its ticket's.
+	Body     string  // Body is the full text which was matched.
+	Score    float64 // Score is the relevance, higher is better.
+}
+
+// searchBackend is a full-text search implementation.
+type searchBackend interface {
+	// setup prepares the backend, failing if it isn't supported.
+	setup() error
+	// search finds at most limit documents matching any of the terms, most
+	// relevant first.
+	search(terms []string, limit int) ([]SearchResult, error)
+	// refresh re-indexes a document after it was inserted or updated.
+	refresh(kind SearchKind, id int64)
+	// remove drops a document from the index.
+	remove(kind SearchKind, id int64)
+}
+
+var searcher searchBackend
+
+// setupSearch picks the best search backend available for the database.
+func setupSearch() {
+	var backends []searchBackend
+	switch config.Config.DBConfig.Type {
+	case config.SQLite:
+		backends = append(backends, new(sqliteSearch))
+	case config.MySQL:
+		backends = append(backends, new(mysqlSearch))
+	}
+	backends = append(backends, newMemorySearch())
+
+	for _, b := range backends {
+		if err := b.setup(); err != nil {
+			log.Println("Search backend unavailable, falling back:", err)
+			continue
+		}
+		searcher = b
+		return
+	}
+}
+
+// searchRefresh re-indexes a document after it was inserted or updated. The
+// index of similar tickets is kept up to date along with the search index.
+func searchRefresh(kind SearchKind, id int64) {
+	if searcher != nil {
+		searcher.refresh(kind, id)
+	}
+	if kind == SearchTicket {
+		similarTickets.refresh(id)
+	}
+}
+
+// searchRemove drops a deleted document from the indexes.
+func searchRemove(kind SearchKind, id int64) {
+	if searcher != nil {
+		searcher.remove(kind, id)
+	}
+	if kind == SearchTicket {
+		similarTickets.remove(id)
+	}
+}
+
+// SearchTerms splits a query into lower-cased search terms.
+func SearchTerms(q string) (terms []string) {
+	has := make(map[string]bool)
+	for _, t := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(t) < 2 || has[t] {
+			continue
+		}
+		has[t] = true
+		terms = append(terms, t)
+	}
+	return
+}
+
+// Search finds at most limit tickets, comments and announcements matching the
+// query, most relevant first.
+func Search(q string, limit int) ([]SearchResult, error) {
+	terms := SearchTerms(q)
+	if len(terms) == 0 || searcher == nil {
+		return nil, nil
+	}
+
+	results, err := searcher.search(terms, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Comments are shown under the title of their ticket, and are left out if
+	// their ticket no longer exists. Tickets merged into another are left out
+	// as their comments now belong to the other ticket. Tickets and comments
+	// waiting for moderation are left out too.
+	var ids, commentIDs []int64
+	for _, r := range results {
+		if r.Kind != SearchAnnouncement {
+			ids = append(ids, r.TicketID)
+		}
+		if r.Kind == SearchComment {
+			commentIDs = append(commentIDs, r.ID)
+		}
+	}
+	var tickets []Ticket
+	if len(ids) > 0 {
+		engine.Cols("ticket_id", "title", "duplicate_of", "is_pending").In("ticket_id", ids).Find(&tickets)
+	}
+	byID := make(map[int64]Ticket, len(tickets))
+	for _, t := range tickets {
+		byID[t.TicketID] = t
+	}
+	var pending []Comment
+	if len(commentIDs) > 0 {
+		engine.Cols("comment_id").In("comment_id", commentIDs).And("is_pending = ?", true).Find(&pending)
+	}
+	pendingComments := make(map[int64]bool, len(pending))
+	for _, c := range pending {
+		pendingComments[c.CommentID] = true
+	}
+
+	filtered := results[:0]
+	for _, r := range results {
+		if r.Kind != SearchAnnouncement {
+			t, ok := byID[r.TicketID]
+			if !ok || t.DuplicateOf != 0 || t.IsPending {
+				continue
+			}
+			if r.Kind == SearchComment && pendingComments[r.ID] {
+				continue
+			}
+			if r.Kind == SearchComment {
+				r.Title = t.Title
+			}
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered, nil
+}
+
+// searchDoc is the indexed text of a ticket, comment or announcement.
+type searchDoc struct {
+	kind         SearchKind
+	id, ticketID int64
+	title, body  string
+	tags         string
+}
+
+// loadSearchDoc fetches the indexed text of a document from the database.
+func loadSearchDoc(kind SearchKind, id int64) (searchDoc, bool) {
+	switch kind {
+	case SearchTicket:
+		if t, err := GetTicket(id); err == nil {
+			return searchDoc{kind: kind, id: id, ticketID: id,
+				title: t.Title, body: t.Description}, true
+		}
+	case SearchComment:
+		if c, err := GetComment(id); err == nil {
+			return searchDoc{kind: kind, id: id, ticketID: c.TicketID,
+				body: c.Text}, true
+		}
+	case SearchAnnouncement:
+		if a, err := GetAnnouncement(id); err == nil {
+			return searchDoc{kind: kind, id: id, title: a.Title,
+				body: a.Description, tags: a.Tags}, true
+		}
+	}
+	return searchDoc{}, false
+}
+
+// allSearchDocs fetches the indexed text of every document in the database.
+func allSearchDocs() (docs []searchDoc) {
+	var tickets []Ticket
+	engine.Cols("ticket_id", "title", "description").Find(&tickets)
+	for _, t := range tickets {
+		docs = append(docs, searchDoc{kind: SearchTicket, id: t.TicketID,
+			ticketID: t.TicketID, title: t.Title, body: t.Description})
+	}
+	var comments []Comment
+	engine.Cols("comment_id", "ticket_id", "text").Find(&comments)
+	for _, c := range comments {
+		docs = append(docs, searchDoc{kind: SearchComment, id: c.CommentID,
+			ticketID: c.TicketID, body: c.Text})
+	}
+	for _, a := range GetAnnouncements() {
+		docs = append(docs, searchDoc{kind: SearchAnnouncement, id: a.AnnouncementID,
+			title: a.Title, body: a.Description, tags: a.Tags})
+	}
+	return
+}
+
+// sqliteSearch uses an FTS5 virtual table kept up to date by triggers. FTS5 is
+// only available when the SQLite driver is built with the sqlite_fts5 tag.
+type sqliteSearch struct{}
+
+// sqliteSearchTriggers mirror the content tables into the search_fts table.
+// The rowid of a document is its ID multiplied by the number of kinds plus its
+// kind, so each document can be found without an index on the kind.
+var sqliteSearchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS search_ticket_ai AFTER INSERT ON ticket BEGIN
+		INSERT INTO search_fts(rowid, title, body, ticket) VALUES (new.ticket_id*3, new.title, new.description, new.ticket_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_ticket_au AFTER UPDATE OF title, description ON ticket BEGIN
+		DELETE FROM search_fts WHERE rowid = old.ticket_id*3;
+		INSERT INTO search_fts(rowid, title, body, ticket) VALUES (new.ticket_id*3, new.title, new.description, new.ticket_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_ticket_ad AFTER DELETE ON ticket BEGIN
+		DELETE FROM search_fts WHERE rowid = old.ticket_id*3;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_comment_ai AFTER INSERT ON comment BEGIN
+		INSERT INTO search_fts(rowid, title, body, ticket) VALUES (new.comment_id*3+1, '', new.text, new.ticket_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_comment_au AFTER UPDATE OF text, ticket_id ON comment BEGIN
+		DELETE FROM search_fts WHERE rowid = old.comment_id*3+1;
+		INSERT INTO search_fts(rowid, title, body, ticket) VALUES (new.comment_id*3+1, '', new.text, new.ticket_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_comment_ad AFTER DELETE ON comment BEGIN
+		DELETE FROM search_fts WHERE rowid = old.comment_id*3+1;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_announcement_ai AFTER INSERT ON announcement BEGIN
+		INSERT INTO search_fts(rowid, title, body, ticket) VALUES (new.announcement_id*3+2, new.title, new.description || ' ' || replace(new.tags, ',', ' '), 0);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_announcement_au AFTER UPDATE OF title, description, tags ON announcement BEGIN
+		DELETE FROM search_fts WHERE rowid = old.announcement_id*3+2;
+		INSERT INTO search_fts(rowid, title, body, ticket) VALUES (new.announcement_id*3+2, new.title, new.description || ' ' || replace(new.tags, ',', ' '), 0);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_announcement_ad AFTER DELETE ON announcement BEGIN
+		DELETE FROM search_fts WHERE rowid = old.announcement_id*3+2;
+	END`,
+}
+
+// sqliteSearchTriggerNames are the names of the triggers which keep the
+// search_fts table up to date.
+var sqliteSearchTriggerNames = []string{
+	"search_ticket_ai", "search_ticket_au", "search_ticket_ad",
+	"search_comment_ai", "search_comment_au", "search_comment_ad",
+	"search_announcement_ai", "search_announcement_au", "search_announcement_ad",
+}
+
+func (s *sqliteSearch) setup() error {
+	err := s.build()
+	if err != nil {
+		// Without FTS5 the triggers would make every insert fail, so they are
+		// dropped until a binary with FTS5 support rebuilds the index.
+		for _, name := range sqliteSearchTriggerNames {
+			engine.Exec("DROP TRIGGER IF EXISTS " + name)
+		}
+	}
+	return err
+}
+
+// build creates the search table and its triggers, and re-indexes everything
+// in case the triggers were missing while content was posted.
+func (s *sqliteSearch) build() error {
+	_, err := engine.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS search_fts
+		USING fts5(title, body, ticket UNINDEXED, tokenize = 'porter unicode61')`)
+	if err != nil {
+		return err
+	}
+	// The triggers are recreated in case their definition changed.
+	for _, name := range sqliteSearchTriggerNames {
+		if _, err = engine.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return err
+		}
+	}
+	for _, t := range sqliteSearchTriggers {
+		if _, err = engine.Exec(t); err != nil {
+			return err
+		}
+	}
+
+	if _, err = engine.Exec("DELETE FROM search_fts"); err != nil {
+		return err
+	}
+	_, err = engine.Exec(`INSERT INTO search_fts(rowid, title, body, ticket)
+		SELECT ticket_id*3, title, description, ticket_id FROM ticket
+		UNION ALL SELECT comment_id*3+1, '', text, ticket_id FROM comment
+		UNION ALL SELECT announcement_id*3+2, title, description || ' ' || replace(tags, ',', ' '), 0 FROM announcement`)
+	return err
+}
+
+func (s *sqliteSearch) search(terms []string, limit int) ([]SearchResult, error) {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + t + `"`
+	}
+	quoted[len(quoted)-1] += "*" // the last term may still be being typed
+
+	rows, err := engine.QueryString(`SELECT rowid, ticket, title, body, -bm25(search_fts, 5.0, 1.0, 0.0) AS score
+		FROM search_fts WHERE search_fts MATCH ? ORDER BY score DESC LIMIT ?`,
+		strings.Join(quoted, " OR "), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		rowid, _ := strconv.ParseInt(row["rowid"], 10, 64)
+		r := SearchResult{
+			Kind:  SearchKind(rowid % searchKinds),
+			ID:    rowid / searchKinds,
+			Title: row["title"],
+			Body:  row["body"],
+		}
+		r.TicketID, _ = strconv.ParseInt(row["ticket"], 10, 64)
+		r.Score, _ = strconv.ParseFloat(row["score"], 64)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *sqliteSearch) refresh(kind SearchKind, id int64) {}
+
+func (s *sqliteSearch) remove(kind SearchKind, id int64) {}
+
+// mysqlSearch uses FULLTEXT indexes on the content tables.
+type mysqlSearch struct{}
+
+// mysqlSearchIndexes are the FULLTEXT indexes used for searching, along with
+// the query which fetches matches from their table.
+var mysqlSearchIndexes = []struct {
+	kind         SearchKind
+	table, index string
+	columns      string
+	query        string
+}{
+	{SearchTicket, "ticket", "ft_ticket", "title, description",
+		"SELECT ticket_id AS id, ticket_id AS ticket, title, description AS body"},
+	{SearchComment, "comment", "ft_comment", "text",
+		"SELECT comment_id AS id, ticket_id AS ticket, '' AS title, text AS body"},
+	{SearchAnnouncement, "announcement", "ft_announcement", "title, description, tags",
+		"SELECT announcement_id AS id, 0 AS ticket, title, description AS body"},
+}
+
+func (s *mysqlSearch) setup() error {
+	for _, ix := range mysqlSearchIndexes {
+		exists, err := engine.SQL(`SELECT index_name FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`,
+			ix.table, ix.index).Exist()
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		_, err = engine.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD FULLTEXT INDEX `%s` (%s)",
+			ix.table, ix.index, ix.columns))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mysqlSearch) search(terms []string, limit int) ([]SearchResult, error) {
+	q := strings.Join(terms, " ")
+	var results []SearchResult
+	for _, ix := range mysqlSearchIndexes {
+		match := "MATCH(" + ix.columns + ") AGAINST (? IN NATURAL LANGUAGE MODE)"
+		rows, err := engine.QueryString(ix.query+", "+match+" AS score FROM `"+ix.table+
+			"` WHERE "+match+" ORDER BY score DESC LIMIT ?", q, q, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			r := SearchResult{
+				Kind:  ix.kind,
+				Title: row["title"],
+				Body:  row["body"],
+			}
+			r.ID, _ = strconv.ParseInt(row["id"], 10, 64)
+			r.TicketID, _ = strconv.ParseInt(row["ticket"], 10, 64)
+			r.Score, _ = strconv.ParseFloat(row["score"], 64)
+			results = append(results, r)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *mysqlSearch) refresh(kind SearchKind, id int64) {}
+
+func (s *mysqlSearch) remove(kind SearchKind, id int64) {}
+
+const (
+	// memoryTitleWeight is how many times a term in a title counts.
+	memoryTitleWeight = 5
+	// BM25 parameters, see https://en.wikipedia.org/wiki/Okapi_BM25
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type memoryKey struct {
+	kind SearchKind
+	id   int64
+}
+
+type memoryDoc struct {
+	searchDoc
+	length int
+	terms  map[string]int
+}
+
+// memorySearch is an in-process inverted index ranked with BM25, used when
+// the database has no full-text search of its own.
+type memorySearch struct {
+	sync.RWMutex
+	docs     map[memoryKey]*memoryDoc
+	postings map[string]map[memoryKey]int
+	totalLen int
+}
+
+func newMemorySearch() *memorySearch {
+	return &memorySearch{
+		docs:     make(map[memoryKey]*memoryDoc),
+		postings: make(map[string]map[memoryKey]int),
+	}
+}
+
+func (s *memorySearch) setup() error {
+	for _, d := range allSearchDocs() {
+		s.put(d)
+	}
+	return nil
+}
+
+func (s *memorySearch) put(d searchDoc) {
+	s.Lock()
+	defer s.Unlock()
+	key := memoryKey{d.kind, d.id}
+	s.drop(key)
+
+	doc := &memoryDoc{searchDoc: d, terms: make(map[string]int)}
+	for _, t := range SearchTerms(d.title) {
+		doc.terms[t] += memoryTitleWeight
+	}
+	for _, t := range SearchTerms(d.body + " " + d.tags) {
+		doc.terms[t]++
+	}
+	for t, n := range doc.terms {
+		if s.postings[t] == nil {
+			s.postings[t] = make(map[memoryKey]int)
+		}
+		s.postings[t][key] = n
+		doc.length += n
+	}
+	s.docs[key] = doc
+	s.totalLen += doc.length
+}
+
+// drop removes a document, the caller must hold the lock.
+func (s *memorySearch) drop(key memoryKey) {
+	doc, ok := s.docs[key]
+	if !ok {
+		return
+	}
+	for t := range doc.terms {
+		delete(s.postings[t], key)
+		if len(s.postings[t]) == 0 {
+			delete(s.postings, t)
+		}
+	}
+	s.totalLen -= doc.length
+	delete(s.docs, key)
+}
+
+func (s *memorySearch) search(terms []string, limit int) ([]SearchResult, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if len(s.docs) == 0 {
+		return nil, nil
+	}
+
+	n := float64(len(s.docs))
+	avgLen := float64(s.totalLen) / n
+	scores := make(map[memoryKey]float64)
+	for i, t := range terms {
+		matches := []string{t}
+		if i == len(terms)-1 { // the last term may still be being typed
+			matches = matches[:0]
+			for p := range s.postings {
+				if strings.HasPrefix(p, t) {
+					matches = append(matches, p)
+				}
+			}
+		}
+
+		for _, m := range matches {
+			docs := s.postings[m]
+			idf := math.Log(1 + (n-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+			for key, tf := range docs {
+				dl := float64(s.docs[key].length)
+				scores[key] += idf * float64(tf) * (bm25K1 + 1) /
+					(float64(tf) + bm25K1*(1-bm25B+bm25B*dl/avgLen))
+			}
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for key, score := range scores {
+		d := s.docs[key]
+		results = append(results, SearchResult{
+			Kind:     d.kind,
+			ID:       d.id,
+			TicketID: d.ticketID,
+			Title:    d.title,
+			Body:     d.body,
+			Score:    score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score == results[j].Score {
+			return results[i].ID > results[j].ID
+		}
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *memorySearch) refresh(kind SearchKind, id int64) {
+	if d, ok := loadSearchDoc(kind, id); ok {
+		s.put(d)
+	} else {
+		s.remove(kind, id)
+	}
+}
+
+func (s *memorySearch) remove(kind SearchKind, id int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.drop(memoryKey{kind, id})
+}
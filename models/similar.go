@@ -0,0 +1,217 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// similarTitleWeight is how many times a term in a title counts.
+	similarTitleWeight = 2
+	// similarMinScore is the lowest similarity of a ticket worth suggesting.
+	similarMinScore = 0.1
+)
+
+// similarStopWords are common words which say little about what a ticket is
+// about, so they are left out when comparing tickets.
+var similarStopWords = make(map[string]bool)
+
+func init() {
+	for _, w := range strings.Fields(`a about after all also am an and any are as at
+		be been before but by can could did do does doing don for from get got had has
+		have how if in into is it its just like me more most my no not of on one or
+		our out please so some such than that the their them then there these they
+		this to too up us very was we were what when where which who why will with
+		would you your`) {
+		similarStopWords[w] = true
+	}
+}
+
+// similarTerms splits text into the terms compared between tickets, with
+// stop words left out and plurals made singular.
+func similarTerms(text string) (terms []string) {
+	for _, t := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(t) < 2 || similarStopWords[t] {
+			continue
+		}
+		switch {
+		case len(t) > 4 && strings.HasSuffix(t, "ies"):
+			t = t[:len(t)-3] + "y"
+		case len(t) > 3 && strings.HasSuffix(t, "s") &&
+			!strings.HasSuffix(t, "ss") && !strings.HasSuffix(t, "us") && !strings.HasSuffix(t, "is"):
+			t = t[:len(t)-1]
+		}
+		terms = append(terms, t)
+	}
+	return
+}
+
+// similarIndex is an in-process TF-IDF index of the titles and descriptions
+// of tickets, used to suggest existing tickets to students about to post a
+// new one.
+type similarIndex struct {
+	sync.RWMutex
+	docs map[int64]map[string]float64 // docs are the term frequencies of each ticket.
+	df   map[string]int               // df is the number of tickets each term is in.
+}
+
+var similarTickets = &similarIndex{
+	docs: make(map[int64]map[string]float64),
+	df:   make(map[string]int),
+}
+
+// similarTermFrequencies counts the terms of a title and description.
+func similarTermFrequencies(title, description string) map[string]float64 {
+	tf := make(map[string]float64)
+	for _, t := range similarTerms(title) {
+		tf[t] += similarTitleWeight
+	}
+	for _, t := range similarTerms(description) {
+		tf[t]++
+	}
+	return tf
+}
+
+// build indexes every ticket in the database.
+func (s *similarIndex) build() {
+	var tickets []Ticket
+	engine.Cols("ticket_id", "title", "description").Find(&tickets)
+	for _, t := range tickets {
+		s.put(t.TicketID, t.Title, t.Description)
+	}
+}
+
+func (s *similarIndex) put(id int64, title, description string) {
+	s.Lock()
+	defer s.Unlock()
+	s.drop(id)
+	tf := similarTermFrequencies(title, description)
+	for t := range tf {
+		s.df[t]++
+	}
+	s.docs[id] = tf
+}
+
+// drop removes a ticket, the caller must hold the lock.
+func (s *similarIndex) drop(id int64) {
+	for t := range s.docs[id] {
+		if s.df[t]--; s.df[t] <= 0 {
+			delete(s.df, t)
+		}
+	}
+	delete(s.docs, id)
+}
+
+func (s *similarIndex) refresh(id int64) {
+	if t, err := GetTicket(id); err == nil {
+		s.put(id, t.Title, t.Description)
+	} else {
+		s.remove(id)
+	}
+}
+
+func (s *similarIndex) remove(id int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.drop(id)
+}
+
+// weights turns term frequencies into TF-IDF weights, returning them along
+// with their Euclidean norm. The caller must hold the lock.
+func (s *similarIndex) weights(tf map[string]float64) (map[string]float64, float64) {
+	n := float64(len(s.docs))
+	w := make(map[string]float64, len(tf))
+	norm := 0.0
+	for t, f := range tf {
+		df := s.df[t]
+		if df == 0 {
+			continue // terms no ticket has can't make tickets more similar
+		}
+		w[t] = (1 + math.Log(f)) * math.Log(1+n/float64(df))
+		norm += w[t] * w[t]
+	}
+	return w, math.Sqrt(norm)
+}
+
+// similarScore is how similar a ticket is to some text.
+type similarScore struct {
+	id    int64
+	score float64
+}
+
+// rank scores every ticket sharing a term with the title and description by
+// the cosine similarity of their TF-IDF weights, most similar first.
+func (s *similarIndex) rank(title, description string) []similarScore {
+	s.RLock()
+	defer s.RUnlock()
+	query, queryNorm := s.weights(similarTermFrequencies(title, description))
+	if queryNorm == 0 {
+		return nil
+	}
+
+	var scores []similarScore
+	for id, tf := range s.docs {
+		shared := false
+		for t := range query {
+			if tf[t] > 0 {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			continue
+		}
+
+		doc, docNorm := s.weights(tf)
+		dot := 0.0
+		for t, w := range query {
+			dot += w * doc[t]
+		}
+		if score := dot / (queryNorm * docNorm); score >= similarMinScore {
+			scores = append(scores, similarScore{id, score})
+		}
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].score == scores[j].score {
+			return scores[i].id > scores[j].id
+		}
+		return scores[i].score > scores[j].score
+	})
+	return scores
+}
+
+// GetSimilarTickets returns at most limit open tickets similar to the title
+// and description of a ticket being written, most similar first. Closed,
+// archived, merged and pending tickets are left out as they can't be upvoted.
+func GetSimilarTickets(title, description string, limit int) (tickets []Ticket) {
+	scores := similarTickets.rank(title, description)
+	if len(scores) == 0 {
+		return
+	}
+	ids := make([]int64, len(scores))
+	for i, s := range scores {
+		ids[i] = s.id
+	}
+
+	var found []Ticket
+	engine.In("ticket_id", ids).And("archived_unix = 0").And("duplicate_of = 0").
+		And("is_pending = ?", false).NotIn("status", ClosedStatuses()).Find(&found)
+	byID := make(map[int64]Ticket, len(found))
+	for _, t := range found {
+		byID[t.TicketID] = t
+	}
+	for _, s := range scores {
+		if t, ok := byID[s.id]; ok {
+			tickets = append(tickets, t)
+			if len(tickets) == limit {
+				break
+			}
+		}
+	}
+	return
+}
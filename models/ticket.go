@@ -1,20 +1,35 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"log"
+
+	"xorm.io/xorm"
+)
 
 // Ticket represents an issue
 type Ticket struct {
-	TicketID      int64  `xorm:"pk autoincr"`
-	Title         string `xorm:"text"`
-	Category      string `xorm:"text"`
-	CreatedUnix   int64  `xorm:"created"`
-	UpdatedUnix   int64  `xorm:"updated"`
-	Description   string `xorm:"text"`
-	Voters        []string
-	IsRep         bool `xorm:"bool"` // Used for adding badge to emphasise rep tickets
-	IsResolved    bool
-	CommentsCount int       `xorm:"-"`
-	Comments      []Comment `xorm:"-"`
+	TicketID      int64        `xorm:"pk autoincr"`
+	Title         string       `xorm:"text"`
+	Category      string       `xorm:"text"`
+	CreatedUnix   int64        `xorm:"created"`
+	UpdatedUnix   int64        `xorm:"updated"`
+	Description   string       `xorm:"text"`
+	VoteCount     int          `xorm:"notnull default 0 index"`
+	IsRep         bool         `xorm:"bool"` // Used for adding badge to emphasise rep tickets
+	Status        TicketStatus `xorm:"varchar(20) notnull default 'open' index"`
+	DuplicateOf   int64        `xorm:"notnull default 0 index"` // DuplicateOf is the ticket this was merged into, if not zero.
+	IsPinned      bool         `xorm:"notnull default false"`   // IsPinned keeps the ticket at the top of the listings.
+	IsLocked      bool         `xorm:"notnull default false"`   // IsLocked stops new comments and votes.
+	ArchivedUnix  int64        `xorm:"notnull default 0 index"` // ArchivedUnix is when the ticket was archived, if not zero.
+	EscalatedUnix int64        `xorm:"notnull default 0"`       // EscalatedUnix is when the ticket was last escalated to lecturers, if not zero.
+	IsPending     bool         `xorm:"notnull default false"`   // IsPending hides the ticket until a class representative approves it.
+	PendingReason string       // PendingReason is why the ticket is waiting for moderation.
+	PendingToken  string       `xorm:"varchar(64)"`       // PendingToken is the secret in the poster's private link to the ticket while it is pending.
+	AnswerID      int64        `xorm:"notnull default 0"` // AnswerID is the class representative's comment marked as the official answer, if not zero.
+	CommentsCount int          `xorm:"-"`
+	Comments      []Comment    `xorm:"-"`
+	Labels        []Label      `xorm:"-"`
 }
 
 func HasTicketWithCategory(c string) bool {
@@ -24,19 +39,27 @@ func HasTicketWithCategory(c string) bool {
 
 // AddTicket inserts a new ticket into the database
 func AddTicket(t *Ticket) (err error) {
-	_, err = engine.Insert(t)
+	if t.Status == "" {
+		t.Status = StatusOpen
+	}
+	if _, err = engine.Insert(t); err == nil {
+		searchRefresh(SearchTicket, t.TicketID)
+	}
 	return err
 }
 
 // UpdateTicket updates a comment in the database.
 func UpdateTicket(t *Ticket) (err error) {
-	_, err = engine.ID(t.TicketID).Update(t)
+	if _, err = engine.ID(t.TicketID).Update(t); err == nil {
+		searchRefresh(SearchTicket, t.TicketID)
+	}
 	return
 }
 
-// LoadComments loads the comments of the ticket into a non-mapped field.
+// LoadComments loads the comments of the ticket into a non-mapped field,
+// oldest first.
 func (t *Ticket) LoadComments() (err error) {
-	return engine.Where("ticket_id = ?", t.TicketID).Find(&t.Comments)
+	return engine.Where("ticket_id = ?", t.TicketID).Asc("comment_id").Find(&t.Comments)
 }
 
 // GetTicket fetches a ticket based on the TicketID
@@ -58,15 +81,225 @@ func GetTickets() (tickets []Ticket) {
 	return tickets
 }
 
+// IsArchived checks whether the ticket was archived. Archived tickets are
+// read-only.
+func (t Ticket) IsArchived() bool {
+	return t.ArchivedUnix != 0
+}
+
+// TicketFilter narrows down the tickets returned by FindTickets. Tickets merged
+// into another or waiting for moderation are never returned.
+type TicketFilter struct {
+	Categories []string       // Categories restricts to any of the categories, if not empty.
+	Statuses   []TicketStatus // Statuses restricts to any of the statuses, if not empty.
+	From       int64          // From excludes tickets created before it, if not zero.
+	To         int64          // To excludes tickets created after it, if not zero.
+	Assignee   string         // Assignee restricts to tickets assigned to the email, if not empty.
+	Label      int64          // Label restricts to tickets with the label, if not zero.
+	Archived   bool           // Archived restricts to archived tickets, which are left out otherwise.
+	Unanswered bool           // Unanswered restricts to tickets without an official answer or comments by class representatives.
+}
+
+// session creates a database session with the conditions of the filter.
+func (f TicketFilter) session() *xorm.Session {
+	sess := engine.NewSession()
+	sess.Where("duplicate_of = 0 AND is_pending = ?", false)
+	if f.Archived {
+		sess.Where("archived_unix != 0")
+	} else {
+		sess.Where("archived_unix = 0")
+	}
+	if len(f.Categories) > 0 {
+		sess.In("category", f.Categories)
+	}
+	if len(f.Statuses) > 0 {
+		sess.In("status", f.Statuses)
+	}
+	if f.From != 0 {
+		sess.Where("created_unix >= ?", f.From)
+	}
+	if f.To != 0 {
+		sess.Where("created_unix <= ?", f.To)
+	}
+	if f.Assignee != "" {
+		sess.Where("ticket_id IN (SELECT ticket_id FROM assignment WHERE rep_email = ?)", f.Assignee)
+	}
+	if f.Label != 0 {
+		sess.Where("ticket_id IN (SELECT ticket_id FROM ticket_label WHERE label_id = ?)", f.Label)
+	}
+	if f.Unanswered {
+		sess.Where("answer_id = 0 AND ticket_id NOT IN (SELECT ticket_id FROM comment WHERE is_admin = ? AND is_deleted = ?)",
+			true, false)
+	}
+	return sess
+}
+
+// CountTickets returns the number of tickets matching the filter.
+func CountTickets(f TicketFilter) int64 {
+	sess := f.session()
+	defer sess.Close()
+	total, _ := sess.Count(new(Ticket))
+	return total
+}
+
+// FindTickets fetches at most limit tickets matching the filter, starting from
+// start, with pinned tickets first and then in the order of the sort.
+func FindTickets(f TicketFilter, by TicketSort, start, limit int) (tickets []Ticket) {
+	sess := f.session()
+	defer sess.Close()
+	if order := by.sqlOrder(); order != "" {
+		sess.OrderBy(order).Limit(limit, start).Find(&tickets)
+		return
+	}
+
+	// Orders worked out in Go only load the columns needed for ranking for all
+	// the matches, the rest is only loaded for the requested page.
+	var ranked []Ticket
+	sess.Cols("ticket_id", "created_unix", "updated_unix", "vote_count", "is_pinned").Find(&ranked)
+	if err := sortTickets(ranked, by); err != nil {
+		log.Println(err)
+	}
+
+	if start >= len(ranked) {
+		return
+	}
+	if end := start + limit; end < len(ranked) {
+		ranked = ranked[:end]
+	}
+	ranked = ranked[start:]
+
+	ids := make([]int64, len(ranked))
+	for i, t := range ranked {
+		ids[i] = t.TicketID
+	}
+	var found []Ticket
+	engine.In("ticket_id", ids).Find(&found)
+
+	byID := make(map[int64]Ticket, len(found))
+	for _, t := range found {
+		byID[t.TicketID] = t
+	}
+	for _, id := range ids {
+		if t, ok := byID[id]; ok {
+			tickets = append(tickets, t)
+		}
+	}
+	return
+}
+
+// LoadCommentsCounts fills in the number of comments of each ticket with a
+// single query.
+func LoadCommentsCounts(tickets []Ticket) error {
+	if len(tickets) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(tickets))
+	for i, t := range tickets {
+		ids[i] = t.TicketID
+	}
+
+	var counts []struct {
+		TicketID int64
+		Count    int
+	}
+	err := engine.Table(new(Comment)).Select("ticket_id, COUNT(*) AS count").
+		In("ticket_id", ids).And("is_deleted = ? AND is_pending = ?", false, false).GroupBy("ticket_id").Find(&counts)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[int64]int, len(counts))
+	for _, c := range counts {
+		byID[c.TicketID] = c.Count
+	}
+	for i := range tickets {
+		tickets[i].CommentsCount = byID[tickets[i].TicketID]
+	}
+	return nil
+}
+
+// GetUsedCategories returns the distinct categories which have tickets.
+func GetUsedCategories() (categories []string) {
+	var tickets []Ticket
+	engine.Distinct("category").Find(&tickets)
+	for _, t := range tickets {
+		categories = append(categories, t.Category)
+	}
+	return
+}
+
 // GetCategory creates a new array of type Ticket and populates it with tickets of a certain category
 func GetCategory(category string) (tickets []Ticket) {
 	engine.Where("category = ?", category).Find(&tickets)
 	return
 }
 
-// DelTicket deletes a ticket based on the TicketID
-func DelTicket(id int64) (err error) {
-	_, err = engine.ID(id).Delete(&Ticket{})
+// DelTicket deletes a ticket based on the TicketID, along with its comments,
+// votes and everything else recorded about it.
+func DelTicket(id int64) error {
+	var comments []int64
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if err := sess.Table(new(Comment)).Where("ticket_id = ?", id).Cols("comment_id").Find(&comments); err != nil {
+			return nil, err
+		}
+		if _, err := sess.ID(id).Delete(&Ticket{}); err != nil {
+			return nil, err
+		}
+		for _, bean := range []interface{}{new(Comment), new(Vote), new(StatusChange), new(Assignment),
+			new(TicketLabel), new(Escalation)} {
+			if _, err := sess.Where("ticket_id = ?", id).Delete(bean); err != nil {
+				return nil, err
+			}
+		}
+		if err := deleteRevisions(sess, RevisionTicket, id); err != nil {
+			return nil, err
+		}
+		if err := deleteReports(sess, id); err != nil {
+			return nil, err
+		}
+		if err := deletePseudonyms(sess, id); err != nil {
+			return nil, err
+		}
+		if err := deleteFollowers(sess, id); err != nil {
+			return nil, err
+		}
+		if err := deleteAttachmentOwners(sess, "ticket_id", id); err != nil {
+			return nil, err
+		}
+		return nil, deletePolls(sess, "ticket_id", id)
+	})
+	if err != nil {
+		return err
+	}
+	searchRemove(SearchTicket, id)
+	for _, c := range comments {
+		searchRemove(SearchComment, c)
+	}
+	return nil
+}
+
+// SetTicketPinned pins or unpins a ticket, without changing when it was last
+// updated.
+func SetTicketPinned(t *Ticket, pinned bool) error {
+	t.IsPinned = pinned
+	_, err := engine.ID(t.TicketID).Cols("is_pinned").NoAutoTime().Update(t)
+	return err
+}
+
+// SetTicketLocked locks or unlocks a ticket, without changing when it was last
+// updated.
+func SetTicketLocked(t *Ticket, locked bool) error {
+	t.IsLocked = locked
+	_, err := engine.ID(t.TicketID).Cols("is_locked").NoAutoTime().Update(t)
+	return err
+}
+
+// SetTicketAnswer marks a comment as the official answer of a ticket, or
+// unmarks the answer if the comment ID is zero, without changing when the
+// ticket was last updated.
+func SetTicketAnswer(t *Ticket, commentID int64) error {
+	t.AnswerID = commentID
+	_, err := engine.ID(t.TicketID).Cols("answer_id").NoAutoTime().Update(t)
 	return err
 }
 
@@ -74,5 +307,8 @@ func DelTicket(id int64) (err error) {
 // columns, even if the fields are empty.
 func UpdateTicketCols(t *Ticket, cols ...string) error {
 	_, err := engine.ID(t.TicketID).Cols(cols...).Update(t)
+	if err == nil {
+		searchRefresh(SearchTicket, t.TicketID)
+	}
 	return err
 }
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// FindStaleTickets fetches the tickets which aren't archived and haven't been
+// updated, upvoted or commented on since before. Pinned tickets are never
+// stale.
+func FindStaleTickets(before int64) (tickets []Ticket) {
+	engine.Where("archived_unix = 0 AND duplicate_of = 0 AND is_pending = ? AND is_pinned = ?", false, false).
+		And("created_unix < ? AND updated_unix < ?", before, before).
+		And("ticket_id NOT IN (SELECT ticket_id FROM vote WHERE created_unix >= ?)", before).
+		And("ticket_id NOT IN (SELECT ticket_id FROM comment WHERE created_unix >= ?)", before).
+		Find(&tickets)
+	return
+}
+
+// FindResolvedTickets fetches the tickets which are resolved but not archived,
+// and haven't been resolved or updated since before.
+func FindResolvedTickets(before int64) (tickets []Ticket) {
+	engine.Where("archived_unix = 0 AND duplicate_of = 0 AND is_pending = ? AND status = ?", false, StatusResolved).
+		And("updated_unix < ?", before).
+		And("ticket_id NOT IN (SELECT ticket_id FROM status_change WHERE to_status = ? AND created_unix >= ?)",
+			StatusResolved, before).
+		Find(&tickets)
+	return
+}
+
+// SetTicketArchived archives or restores a ticket. Restoring a ticket counts as
+// updating it, so it isn't archived again straight away.
+func SetTicketArchived(t *Ticket, archived bool) error {
+	sess := engine.ID(t.TicketID).Cols("archived_unix")
+	if archived {
+		t.ArchivedUnix = time.Now().Unix()
+		sess.NoAutoTime()
+	} else {
+		t.ArchivedUnix = 0
+	}
+	_, err := sess.Update(t)
+	return err
+}
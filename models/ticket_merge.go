@@ -0,0 +1,167 @@
+package models
+
+import (
+	"errors"
+
+	"xorm.io/xorm"
+)
+
+// ErrInvalidDuplicate is returned when a ticket can't be merged into another.
+var ErrInvalidDuplicate = errors.New("A ticket can only be merged into another ticket which isn't a duplicate")
+
+// ErrInvalidCanonical is returned when the ticket to merge into is pending
+// approval or archived.
+var ErrInvalidCanonical = errors.New("A ticket can't be merged into a pending or archived ticket")
+
+// MergeTicket marks a ticket as a duplicate of a canonical ticket, moving its
+// votes, comments, assignees, labels, followers and the names students posted
+// under to the canonical ticket. Voters who upvoted both tickets are only
+// counted once. The duplicate is kept so its link can redirect to the
+// canonical ticket. It returns the number of votes and comments moved.
+func MergeTicket(dup, canonical *Ticket) (votes, comments int, err error) {
+	if dup.TicketID == canonical.TicketID || dup.DuplicateOf != 0 || canonical.DuplicateOf != 0 {
+		return 0, 0, ErrInvalidDuplicate
+	}
+	if canonical.IsPending || canonical.IsArchived() {
+		return 0, 0, ErrInvalidCanonical
+	}
+
+	var movedComments []Comment
+	_, err = engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		var dupVotes, canonicalVotes []Vote
+		if err := sess.Where("ticket_id = ?", dup.TicketID).Find(&dupVotes); err != nil {
+			return nil, err
+		}
+		if err := sess.Where("ticket_id = ?", canonical.TicketID).Find(&canonicalVotes); err != nil {
+			return nil, err
+		}
+
+		voted := make(map[string]bool, len(canonicalVotes))
+		for _, v := range canonicalVotes {
+			voted[v.VoterHash] = true
+		}
+		var moved, dropped []int64
+		for _, v := range dupVotes {
+			if voted[v.VoterHash] {
+				dropped = append(dropped, v.VoteID)
+			} else {
+				moved = append(moved, v.VoteID)
+			}
+		}
+
+		if len(dropped) > 0 {
+			if _, err := sess.In("vote_id", dropped).Delete(new(Vote)); err != nil {
+				return nil, err
+			}
+		}
+		if len(moved) > 0 {
+			_, err := sess.Table(new(Vote)).In("vote_id", moved).
+				Update(map[string]interface{}{"ticket_id": canonical.TicketID})
+			if err != nil {
+				return nil, err
+			}
+		}
+		votes = len(moved)
+
+		if err := mergePseudonyms(sess, dup.TicketID, canonical.TicketID); err != nil {
+			return nil, err
+		}
+		if err := sess.Where("ticket_id = ?", dup.TicketID).Find(&movedComments); err != nil {
+			return nil, err
+		}
+		_, err := sess.Table(new(Comment)).Where("ticket_id = ?", dup.TicketID).
+			Update(map[string]interface{}{"ticket_id": canonical.TicketID})
+		if err != nil {
+			return nil, err
+		}
+		comments = len(movedComments)
+
+		// The representatives assigned to the duplicate keep following it on
+		// the canonical ticket.
+		var assignments []Assignment
+		if err := sess.Where("ticket_id = ?", dup.TicketID).Find(&assignments); err != nil {
+			return nil, err
+		}
+		for _, a := range assignments {
+			has, err := sess.Where("ticket_id = ? AND rep_email = ?", canonical.TicketID, a.RepEmail).
+				Exist(new(Assignment))
+			if err != nil {
+				return nil, err
+			}
+			if !has {
+				if _, err = sess.Insert(&Assignment{TicketID: canonical.TicketID, RepEmail: a.RepEmail}); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		var labels []TicketLabel
+		if err := sess.Where("ticket_id = ?", dup.TicketID).Find(&labels); err != nil {
+			return nil, err
+		}
+		for _, l := range labels {
+			has, err := sess.Where("ticket_id = ? AND label_id = ?", canonical.TicketID, l.LabelID).
+				Exist(new(TicketLabel))
+			if err != nil {
+				return nil, err
+			}
+			if !has {
+				if _, err = sess.Insert(&TicketLabel{TicketID: canonical.TicketID, LabelID: l.LabelID}); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// Followers of the duplicate follow the canonical ticket instead,
+		// unless they already do.
+		var followers []Follower
+		if err := sess.Where("ticket_id = ?", dup.TicketID).Find(&followers); err != nil {
+			return nil, err
+		}
+		for _, f := range followers {
+			has, err := sess.Where("ticket_id = ? AND email_hash = ?", canonical.TicketID, f.EmailHash).
+				Exist(new(Follower))
+			if err != nil {
+				return nil, err
+			}
+			if has {
+				_, err = sess.ID(f.FollowerID).Delete(new(Follower))
+			} else {
+				_, err = sess.Table(new(Follower)).ID(f.FollowerID).
+					Update(map[string]interface{}{"ticket_id": canonical.TicketID})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Tickets merged into the duplicate earlier now point to the
+		// canonical ticket directly.
+		_, err = sess.Table(new(Ticket)).Where("duplicate_of = ?", dup.TicketID).
+			Update(map[string]interface{}{"duplicate_of": canonical.TicketID})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = sess.Table(new(Ticket)).Where("ticket_id = ?", dup.TicketID).
+			Update(map[string]interface{}{"duplicate_of": canonical.TicketID, "vote_count": 0})
+		if err != nil {
+			return nil, err
+		}
+		_, err = sess.Exec("UPDATE ticket SET vote_count = vote_count + ? WHERE ticket_id = ?",
+			votes, canonical.TicketID)
+		return nil, err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dup.DuplicateOf = canonical.TicketID
+	dup.VoteCount = 0
+	canonical.VoteCount += votes
+	searchRefresh(SearchTicket, dup.TicketID)
+	for _, c := range movedComments {
+		searchRefresh(SearchComment, c.CommentID)
+	}
+	return votes, comments, nil
+}
@@ -1,10 +1,83 @@
 package models
 
 import (
+	"math"
+	"sort"
 	"time"
+
+	"github.com/hw-cs-reps/platform/config"
+)
+
+const (
+	// defaultHotPeriod is used if the configuration has no decay period.
+	defaultHotPeriod = 86400 // NOTE: 86400 is 1 day in seconds
+	// defaultHotGravity is used if the configuration has no gravity.
+	defaultHotGravity = 1
+)
+
+// TicketSort is an order in which tickets are listed.
+type TicketSort string
+
+const (
+	// SortHot lists tickets by upvotes, decaying with age.
+	SortHot TicketSort = "hot"
+	// SortNewest lists the most recently posted tickets first.
+	SortNewest TicketSort = "new"
+	// SortVotes lists the most upvoted tickets first.
+	SortVotes TicketSort = "top"
+	// SortComments lists the most commented tickets first.
+	SortComments TicketSort = "discussed"
+	// SortActivity lists the most recently updated or commented tickets first.
+	SortActivity TicketSort = "activity"
+	// SortUnanswered lists the tickets no class representative has commented
+	// on first, then the rest, each by hotness.
+	SortUnanswered TicketSort = "unanswered"
 )
 
-var hotnessDelta float64 = 86400 // NOTE: 86400 is 1 day in seconds
+// TicketSorts are all the orders in which tickets can be listed.
+var TicketSorts = []TicketSort{SortHot, SortNewest, SortVotes, SortComments, SortActivity, SortUnanswered}
+
+// IsValid checks whether the sort is one of TicketSorts.
+func (s TicketSort) IsValid() bool {
+	for _, v := range TicketSorts {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the human readable name of the sort.
+func (s TicketSort) Name() string {
+	switch s {
+	case SortHot:
+		return "Hot"
+	case SortNewest:
+		return "Newest"
+	case SortVotes:
+		return "Most voted"
+	case SortComments:
+		return "Most discussed"
+	case SortActivity:
+		return "Recently active"
+	case SortUnanswered:
+		return "Unanswered by reps"
+	}
+	return string(s)
+}
+
+// sqlOrder returns the ORDER BY clause listing tickets in the order, pinned
+// tickets first, or an empty string if the order can only be worked out in
+// Go. Tickets which are equal in the order are listed newest first.
+func (s TicketSort) sqlOrder() string {
+	switch s {
+	case SortNewest:
+		return "is_pinned DESC, created_unix DESC, ticket_id DESC"
+	case SortVotes:
+		return "is_pinned DESC, vote_count DESC, created_unix DESC, ticket_id DESC"
+	}
+	return ""
+}
 
 // HotTicket implements sort.Interface for []Ticket based on iota score diminished
 // by time.
@@ -18,14 +91,112 @@ func (p HotTickets) Swap(i, j int) {
 	p[i], p[j] = p[j], p[i]
 }
 
+// hotDecay returns the decay period and gravity from the configuration.
+func hotDecay() (period, gravity float64) {
+	period, gravity = defaultHotPeriod, defaultHotGravity
+	if config.Config.HotDecay.Period > 0 {
+		period = float64(config.Config.HotDecay.Period)
+	}
+	if config.Config.HotDecay.Gravity > 0 {
+		gravity = config.Config.HotDecay.Gravity
+	}
+	return
+}
+
 func getHotScore(p Ticket) float64 {
-	t := time.Now().Sub(time.Unix(p.CreatedUnix, 0)).Seconds() / hotnessDelta
+	period, gravity := hotDecay()
+	t := time.Now().Sub(time.Unix(p.CreatedUnix, 0)).Seconds() / period
 	if t < 1 {
-		return float64(len(p.Voters))
+		return float64(p.VoteCount)
 	}
-	return float64(len(p.Voters)) / t
+	return float64(p.VoteCount) / math.Pow(t, gravity)
 }
 
 func (p HotTickets) Less(i, j int) bool {
 	return getHotScore(p[i]) > getHotScore(p[j])
 }
+
+// commentStats are the figures about the comments of a ticket used to sort
+// tickets.
+type commentStats struct {
+	TicketID int64
+	Count    int   // Count is the number of comments which aren't deleted.
+	LastUnix int64 // LastUnix is when the last comment was posted.
+	Reps     int   // Reps is the number of comments by class representatives.
+}
+
+// commentStatsBatch is how many tickets the comment figures are fetched for
+// at once, to stay within the limit of query parameters.
+const commentStatsBatch = 500
+
+// getCommentStats returns the comment figures of the tickets with comments
+// among the tickets with the IDs.
+func getCommentStats(ids []int64) (map[int64]commentStats, error) {
+	byID := make(map[int64]commentStats, len(ids))
+	for start := 0; start < len(ids); start += commentStatsBatch {
+		end := start + commentStatsBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var stats []commentStats
+		err := engine.Table(new(Comment)).
+			Select("ticket_id, COUNT(*) AS count, MAX(created_unix) AS last_unix, SUM(is_admin) AS reps").
+			Where("is_deleted = ? AND is_pending = ?", false, false).In("ticket_id", ids[start:end]).
+			GroupBy("ticket_id").Find(&stats)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range stats {
+			byID[s.TicketID] = s
+		}
+	}
+	return byID, nil
+}
+
+// sortTickets sorts tickets in the order, with pinned tickets first. Tickets
+// which are equal in the order are sorted by hotness.
+func sortTickets(tickets []Ticket, by TicketSort) error {
+	sort.Sort(HotTickets(tickets))
+
+	switch by {
+	case SortNewest:
+		sort.SliceStable(tickets, func(i, j int) bool {
+			return tickets[i].CreatedUnix > tickets[j].CreatedUnix
+		})
+	case SortVotes:
+		sort.SliceStable(tickets, func(i, j int) bool {
+			return tickets[i].VoteCount > tickets[j].VoteCount
+		})
+	case SortComments, SortActivity, SortUnanswered:
+		ids := make([]int64, len(tickets))
+		for i, t := range tickets {
+			ids[i] = t.TicketID
+		}
+		stats, err := getCommentStats(ids)
+		if err != nil {
+			return err
+		}
+		lastActive := func(t Ticket) int64 {
+			if last := stats[t.TicketID].LastUnix; last > t.UpdatedUnix {
+				return last
+			}
+			return t.UpdatedUnix
+		}
+		sort.SliceStable(tickets, func(i, j int) bool {
+			a, b := tickets[i], tickets[j]
+			switch by {
+			case SortComments:
+				return stats[a.TicketID].Count > stats[b.TicketID].Count
+			case SortActivity:
+				return lastActive(a) > lastActive(b)
+			default:
+				return stats[a.TicketID].Reps == 0 && stats[b.TicketID].Reps > 0
+			}
+		})
+	}
+
+	sort.SliceStable(tickets, func(i, j int) bool {
+		return tickets[i].IsPinned && !tickets[j].IsPinned
+	})
+	return nil
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+
+	"xorm.io/xorm"
+)
+
+// TicketStatus is the stage of the workflow a ticket is in.
+type TicketStatus string
+
+const (
+	// StatusOpen is a newly posted ticket which no rep has looked at yet.
+	StatusOpen TicketStatus = "open"
+	// StatusAcknowledged is a ticket the reps are aware of.
+	StatusAcknowledged TicketStatus = "acknowledged"
+	// StatusRaised is a ticket the reps have raised with staff.
+	StatusRaised TicketStatus = "raised"
+	// StatusInProgress is a ticket which is being worked on.
+	StatusInProgress TicketStatus = "in-progress"
+	// StatusResolved is a ticket which has been dealt with.
+	StatusResolved TicketStatus = "resolved"
+	// StatusWontFix is a ticket which will not be acted upon.
+	StatusWontFix TicketStatus = "wont-fix"
+)
+
+// TicketStatuses lists every status in workflow order.
+var TicketStatuses = []TicketStatus{StatusOpen, StatusAcknowledged,
+	StatusRaised, StatusInProgress, StatusResolved, StatusWontFix}
+
+// ticketTransitions are the statuses a ticket may move to from each status.
+var ticketTransitions = map[TicketStatus][]TicketStatus{
+	StatusOpen:         {StatusAcknowledged, StatusRaised, StatusInProgress, StatusResolved, StatusWontFix},
+	StatusAcknowledged: {StatusRaised, StatusInProgress, StatusResolved, StatusWontFix},
+	StatusRaised:       {StatusInProgress, StatusResolved, StatusWontFix},
+	StatusInProgress:   {StatusRaised, StatusResolved, StatusWontFix},
+	StatusResolved:     {StatusOpen},
+	StatusWontFix:      {StatusOpen},
+}
+
+// Name returns the human readable name of the status.
+func (s TicketStatus) Name() string {
+	switch s {
+	case StatusOpen:
+		return "Open"
+	case StatusAcknowledged:
+		return "Acknowledged"
+	case StatusRaised:
+		return "Raised with staff"
+	case StatusInProgress:
+		return "In progress"
+	case StatusResolved:
+		return "Resolved"
+	case StatusWontFix:
+		return "Won't fix"
+	}
+	return string(s)
+}
+
+// IsValid checks whether the status is a known status.
+func (s TicketStatus) IsValid() bool {
+	_, ok := ticketTransitions[s]
+	return ok
+}
+
+// IsClosed checks whether the status ends the workflow. Closed tickets can't
+// be upvoted.
+func (s TicketStatus) IsClosed() bool {
+	return s == StatusResolved || s == StatusWontFix
+}
+
+// Next returns the statuses a ticket may move to from this status.
+func (s TicketStatus) Next() []TicketStatus {
+	return ticketTransitions[s]
+}
+
+// CanMoveTo checks whether a ticket may move from this status to another.
+func (s TicketStatus) CanMoveTo(to TicketStatus) bool {
+	for _, n := range ticketTransitions[s] {
+		if n == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveStatuses returns the statuses which are not closed.
+func ActiveStatuses() (statuses []TicketStatus) {
+	for _, s := range TicketStatuses {
+		if !s.IsClosed() {
+			statuses = append(statuses, s)
+		}
+	}
+	return
+}
+
+// ClosedStatuses returns the statuses which are closed.
+func ClosedStatuses() (statuses []TicketStatus) {
+	for _, s := range TicketStatuses {
+		if s.IsClosed() {
+			statuses = append(statuses, s)
+		}
+	}
+	return
+}
+
+// StatusChange is a move of a ticket from one status to another, which makes
+// up the status timeline of the ticket.
+type StatusChange struct {
+	StatusChangeID int64        `xorm:"pk autoincr"`
+	TicketID       int64        `xorm:"notnull index"`
+	FromStatus     TicketStatus `xorm:"varchar(20)"`
+	ToStatus       TicketStatus `xorm:"varchar(20)"`
+	Note           string       `xorm:"text"`
+	Admin          string
+	CreatedUnix    int64 `xorm:"created"`
+}
+
+var (
+	// ErrInvalidTransition is returned when a ticket can't move to a status.
+	ErrInvalidTransition = errors.New("The ticket can't be moved to that status")
+	// ErrNoteRequired is returned when a status change has no note.
+	ErrNoteRequired = errors.New("A note is required when changing the status")
+)
+
+// ChangeTicketStatus moves a ticket to another status and records it in the
+// status timeline of the ticket.
+func ChangeTicketStatus(t *Ticket, to TicketStatus, note, admin string) error {
+	if !t.Status.CanMoveTo(to) {
+		return ErrInvalidTransition
+	}
+	if note == "" {
+		return ErrNoteRequired
+	}
+
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		// Only move the ticket if nobody else moved it in the meantime.
+		n, err := sess.Table(new(Ticket)).Where("ticket_id = ? AND status = ?", t.TicketID, t.Status).
+			Update(map[string]interface{}{"status": to})
+		if err != nil {
+			return nil, err
+		} else if n == 0 {
+			return nil, ErrInvalidTransition
+		}
+
+		_, err = sess.Insert(&StatusChange{
+			TicketID:   t.TicketID,
+			FromStatus: t.Status,
+			ToStatus:   to,
+			Note:       note,
+			Admin:      admin,
+		})
+		return nil, err
+	})
+	if err == nil {
+		t.Status = to
+	}
+	return err
+}
+
+// GetStatusChanges fetches the status timeline of a ticket, oldest first.
+func GetStatusChanges(ticketID int64) (changes []StatusChange) {
+	engine.Where("ticket_id = ?", ticketID).Asc("created_unix", "status_change_id").Find(&changes)
+	return
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"xorm.io/xorm"
+)
+
+// Vote represents an upvote of a ticket by an anonymous voter.
+type Vote struct {
+	VoteID      int64  `xorm:"pk autoincr"`
+	TicketID    int64  `xorm:"notnull unique(vote)"`
+	VoterHash   string `xorm:"varchar(64) notnull unique(vote)"`
+	CreatedUnix int64  `xorm:"created"`
+}
+
+// HasVoted checks whether a voter has upvoted a ticket.
+func HasVoted(ticketID int64, voterHash string) bool {
+	has, _ := engine.Where("ticket_id = ? AND voter_hash = ?", ticketID, voterHash).Exist(new(Vote))
+	return has
+}
+
+// AddVote upvotes a ticket on behalf of a voter, and returns whether the vote
+// was counted. Voters who have already upvoted the ticket aren't counted again.
+func AddVote(t *Ticket, voterHash string) (bool, error) {
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		if _, err := sess.Insert(&Vote{TicketID: t.TicketID, VoterHash: voterHash}); err != nil {
+			return nil, err
+		}
+		_, err := sess.Exec("UPDATE ticket SET vote_count = vote_count + 1 WHERE ticket_id = ?", t.TicketID)
+		return nil, err
+	})
+	if err != nil {
+		// The insert fails on the unique constraint if the voter has already
+		// voted, even when racing another request.
+		if HasVoted(t.TicketID, voterHash) {
+			return false, nil
+		}
+		return false, err
+	}
+	t.VoteCount++
+	return true, nil
+}
+
+// RemoveVote retracts the upvote of a voter from a ticket, and returns whether
+// there was a vote to retract.
+func RemoveVote(t *Ticket, voterHash string) (bool, error) {
+	removed := false
+	_, err := engine.Transaction(func(sess *xorm.Session) (interface{}, error) {
+		n, err := sess.Where("ticket_id = ? AND voter_hash = ?", t.TicketID, voterHash).Delete(new(Vote))
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		removed = true
+		_, err = sess.Exec("UPDATE ticket SET vote_count = vote_count - 1 WHERE ticket_id = ?", t.TicketID)
+		return nil, err
+	})
+	if err != nil {
+		return false, err
+	}
+	if removed {
+		t.VoteCount--
+	}
+	return removed, nil
+}
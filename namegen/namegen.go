@@ -46,3 +46,12 @@ func GetName() (name string) {
 	name = strings.Title(name)
 	return
 }
+
+// GetNameFromSeed returns the name picked by a seed, so that the same seed
+// always gives the same name.
+func GetNameFromSeed(seed uint64) (name string) {
+	name = fmt.Sprintf("%s %s", ADJ[seed%uint64(len(ADJ))],
+		NOUN[seed/uint64(len(ADJ))%uint64(len(NOUN))])
+	name = strings.Title(name)
+	return
+}
@@ -76,6 +76,9 @@ func AnnouncementHandler(ctx *emmanuel.Context, sess session.Store, f *session.F
 	ctx.Data["csrf_token"] = x.GetToken()
 	ctx.Data["FormattedPost"] = template.HTML(markdownToHTML(announcement.Description))
 	ctx.Data["Announcement"] = announcement
+	ctx.Data["Polls"] = getPollViews(models.GetAnnouncementPolls(announcement.AnnouncementID),
+		userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")), ctx.Data["IsAdmin"] == 1, true)
+	ctx.Data["PollTypes"] = models.PollTypes
 	ctx.Data["HasScope"] = 1
 	ctx.HTML(200, "announcement")
 }
@@ -86,9 +89,31 @@ func NewAnnouncementHandler(ctx *emmanuel.Context, sess session.Store, f *sessio
 	ctx.Data["IsAnnouncements"] = 1
 	ctx.Data["Announcement"] = 1
 	ctx.Data["HasScope"] = 1
+	setAttachmentLimits(ctx)
 	ctx.HTML(200, "new-ticket")
 }
 
+// addAnnouncement inserts a new announcement and logs it as created by the
+// admin.
+func addAnnouncement(a *models.Announcement, admin string) error {
+	if err := models.AddAnnouncement(a); err != nil {
+		return err
+	}
+	r := models.AnnouncementRevision(a)
+	r.Admin = admin
+	if err := models.AddRevision(nil, r); err != nil {
+		return err
+	}
+
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Announcement \"" + a.Title + "\"",
+		Description: "Created",
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
 // PostNewAnnouncementHandler post response for posting new announcement.
 func PostNewAnnouncementHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
 	title := strings.TrimFunc(ctx.QueryTrim("title"), IsImproperChar)
@@ -100,13 +125,23 @@ func PostNewAnnouncementHandler(ctx *emmanuel.Context, sess session.Store, f *se
 		return
 	}
 
+	attachments, hashes, err := saveAttachments(ctx, announcementAttachmentTypes)
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/a/new")
+		return
+	}
+
 	announcement := models.Announcement{
 		Title:       title,
-		Description: text,
+		Description: text + attachments,
 		Tags:        ctx.QueryTrim("tags"),
 	}
 
-	err := models.AddAnnouncement(&announcement)
+	err = addAnnouncement(&announcement, ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err == nil {
+		err = models.AddAttachmentOwners(hashes, 0, announcement.AnnouncementID)
+	}
 	if err != nil {
 		log.Println(err)
 		f.Error("Failed to add ticket")
@@ -114,13 +149,6 @@ func PostNewAnnouncementHandler(ctx *emmanuel.Context, sess session.Store, f *se
 		return
 	}
 
-	m := models.Moderation{
-		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
-		Title:       "Announcement \"" + title + "\"",
-		Description: "Created",
-	}
-	models.AddModeration(&m)
-
 	ctx.Redirect(fmt.Sprintf("/a/%d", announcement.AnnouncementID))
 }
 
@@ -152,12 +180,14 @@ func PostAnnouncementEditHandler(ctx *emmanuel.Context, sess session.Store, f *s
 	title := ctx.QueryTrim("title")
 	text := ctx.QueryTrim("text")
 
-	err = models.UpdateAnnouncement(&models.Announcement{
+	edited := &models.Announcement{
 		AnnouncementID: announcement.AnnouncementID,
 		Title:          title,
 		Description:    text,
 		Tags:           ctx.QueryTrim("tags"),
-	})
+	}
+	// Tags may be cleared, unlike the title and description.
+	err = models.UpdateAnnouncementCols(edited, "title", "description", "tags")
 	if err != nil {
 		panic(err)
 	}
@@ -169,12 +199,40 @@ func PostAnnouncementEditHandler(ctx *emmanuel.Context, sess session.Store, f *s
 	}
 	models.AddModeration(&m)
 
+	if edited.Title != announcement.Title || edited.Description != announcement.Description ||
+		edited.Tags != announcement.Tags {
+		r := models.AnnouncementRevision(edited)
+		r.Admin = m.Admin
+		if err = models.AddRevision(models.AnnouncementRevision(announcement), r); err != nil {
+			log.Println(err)
+		}
+	}
+
 	ctx.Redirect(fmt.Sprintf("/a/%d", ctx.ParamsInt64("id")))
 }
 
+// deleteAnnouncement deletes an announcement and logs it as done by the admin.
+func deleteAnnouncement(a *models.Announcement, admin string) {
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Announcement \"" + a.Title + "\"",
+		Description: "Deleted",
+	}
+	models.AddModeration(&m)
+
+	models.DelAnnouncement(a.AnnouncementID)
+	removeUnusedAttachments()
+}
+
 // PostAnnouncementDeleteHandler response for deleting an announcement.
 func PostAnnouncementDeleteHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
-	models.DelAnnouncement(ctx.ParamsInt64("id"))
+	a, err := models.GetAnnouncement(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Announcement not found!")
+		ctx.Redirect("/a")
+		return
+	}
+	deleteAnnouncement(a, ctx.Data["User"].(config.ClassRepresentative).Name)
 	f.Success("Announcement deleted!")
 	ctx.Redirect("/a")
 }
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+var errNotAnswer = errors.New("Only comments posted as a class representative can be the official answer!")
+
+// answerTicket marks a comment as the official answer of a ticket, or unmarks
+// the answer if the comment ID is zero, and logs it as done by the admin.
+func answerTicket(ticket *models.Ticket, commentID int64, reason, admin string) error {
+	if ticket.IsArchived() {
+		return errTicketArchived
+	}
+	if commentID == ticket.AnswerID {
+		if commentID == 0 {
+			return errors.New("The ticket has no official answer!")
+		}
+		return errors.New("The comment is already the official answer!")
+	}
+
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Unmarked the official answer",
+		Reason:      reason,
+	}
+	if commentID != 0 {
+		c, err := models.GetComment(commentID)
+		if err != nil || c.TicketID != ticket.TicketID || c.IsDeleted || c.IsPending {
+			return errors.New("Comment not found!")
+		}
+		if !c.IsAdmin {
+			return errNotAnswer
+		}
+		m.Description = "Marked the comment by " + c.PosterID + " as the official answer"
+	}
+
+	if err := models.SetTicketAnswer(ticket, commentID); err != nil {
+		return err
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostTicketAnswerHandler response for marking or unmarking the official
+// answer of a ticket.
+func PostTicketAnswerHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+
+	commentID := ctx.QueryInt64("comment")
+	err = answerTicket(ticket, commentID, ctx.QueryTrim("reason"), ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		f.Error(err.Error())
+	} else if commentID != 0 {
+		f.Success("Official answer marked!")
+	} else {
+		f.Success("Official answer unmarked!")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
+
+// APIAnswerTicketHandler response for marking or unmarking the official
+// answer of a ticket.
+func APIAnswerTicketHandler(ctx *emmanuel.Context) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	var body struct {
+		CommentID int64  `json:"comment_id"`
+		Reason    string `json:"reason"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = answerTicket(ticket, body.CommentID, strings.TrimSpace(body.Reason),
+		ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
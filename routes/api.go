@@ -0,0 +1,745 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+const (
+	// apiDefaultPerPage is the page size used when a client doesn't ask for
+	// one.
+	apiDefaultPerPage = 20
+	// apiMaxPerPage is the largest page size a client may ask for.
+	apiMaxPerPage = 100
+)
+
+// apiError is the body of an unsuccessful API response.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiPagination describes which part of a listing is in an API response.
+type apiPagination struct {
+	Page       int   `json:"page"`
+	PerPage    int   `json:"per_page"`
+	Total      int64 `json:"total"`
+	TotalPages int64 `json:"total_pages"`
+}
+
+// apiList is the body of a paginated API response.
+type apiList struct {
+	Data       interface{}   `json:"data"`
+	Pagination apiPagination `json:"pagination"`
+}
+
+// apiTicket is the public representation of a ticket. It leaves out the voter
+// hashes, which must never leave the server. The comments count and labels are
+// only set if they were loaded into the ticket.
+type apiTicket struct {
+	ID            int64    `json:"id"`
+	Title         string   `json:"title"`
+	Category      string   `json:"category"`
+	Description   string   `json:"description"`
+	Upvotes       int      `json:"upvotes"`
+	IsRep         bool     `json:"is_rep"`
+	Status        string   `json:"status"`
+	IsClosed      bool     `json:"is_closed"`
+	DuplicateOf   int64    `json:"duplicate_of,omitempty"`
+	IsPinned      bool     `json:"is_pinned"`
+	IsLocked      bool     `json:"is_locked"`
+	ArchivedUnix  int64    `json:"archived_unix,omitempty"`
+	EscalatedUnix int64    `json:"escalated_unix,omitempty"`
+	AnswerID      int64    `json:"answer_id,omitempty"`
+	IsPending     bool     `json:"is_pending,omitempty"`
+	PrivateURL    string   `json:"private_url,omitempty"` // PrivateURL is only given to the poster of a pending ticket.
+	Labels        []string `json:"labels"`
+	CommentsCount int      `json:"comments_count"`
+	CreatedUnix   int64    `json:"created_unix"`
+	UpdatedUnix   int64    `json:"updated_unix"`
+}
+
+func newAPITicket(t *models.Ticket) apiTicket {
+	labels := make([]string, len(t.Labels))
+	for i, l := range t.Labels {
+		labels[i] = l.Name
+	}
+	return apiTicket{
+		ID:            t.TicketID,
+		Title:         t.Title,
+		Category:      t.Category,
+		Description:   t.Description,
+		Upvotes:       t.VoteCount,
+		IsRep:         t.IsRep,
+		Status:        string(t.Status),
+		IsClosed:      t.Status.IsClosed(),
+		DuplicateOf:   t.DuplicateOf,
+		IsPinned:      t.IsPinned,
+		IsLocked:      t.IsLocked,
+		ArchivedUnix:  t.ArchivedUnix,
+		EscalatedUnix: t.EscalatedUnix,
+		AnswerID:      t.AnswerID,
+		IsPending:     t.IsPending,
+		Labels:        labels,
+		CommentsCount: t.CommentsCount,
+		CreatedUnix:   t.CreatedUnix,
+		UpdatedUnix:   t.UpdatedUnix,
+	}
+}
+
+// newAPITicketWithCount loads the comments count and labels of a single ticket
+// and converts it to its public representation.
+func newAPITicketWithCount(t *models.Ticket) apiTicket {
+	t.CommentsCount = int(models.CountComments(t.TicketID))
+	t.Labels = models.GetTicketLabels(t.TicketID)
+	return newAPITicket(t)
+}
+
+// apiComment is the public representation of a comment. The parent may be a
+// deleted comment, which isn't listed.
+type apiComment struct {
+	ID          int64  `json:"id"`
+	TicketID    int64  `json:"ticket_id"`
+	ParentID    int64  `json:"parent_id,omitempty"`
+	Poster      string `json:"poster"`
+	IsAdmin     bool   `json:"is_admin"`
+	IsOP        bool   `json:"is_op"`
+	Text        string `json:"text"`
+	IsPending   bool   `json:"is_pending,omitempty"`
+	CreatedUnix int64  `json:"created_unix"`
+	UpdatedUnix int64  `json:"updated_unix"`
+}
+
+func newAPIComment(c *models.Comment) apiComment {
+	return apiComment{
+		ID:          c.CommentID,
+		TicketID:    c.TicketID,
+		ParentID:    c.ParentID,
+		Poster:      c.PosterID,
+		IsAdmin:     c.IsAdmin,
+		IsOP:        c.IsPoster,
+		Text:        c.Text,
+		IsPending:   c.IsPending,
+		CreatedUnix: c.CreatedUnix,
+		UpdatedUnix: c.UpdatedUnix,
+	}
+}
+
+// apiAnnouncement is the public representation of an announcement.
+type apiAnnouncement struct {
+	ID          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Tags        []string `json:"tags"`
+	Description string   `json:"description"`
+	CreatedUnix int64    `json:"created_unix"`
+	UpdatedUnix int64    `json:"updated_unix"`
+}
+
+func newAPIAnnouncement(a *models.Announcement) apiAnnouncement {
+	tags := []string{}
+	for _, t := range strings.Split(a.Tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return apiAnnouncement{
+		ID:          a.AnnouncementID,
+		Title:       a.Title,
+		Tags:        tags,
+		Description: a.Description,
+		CreatedUnix: a.CreatedUnix,
+		UpdatedUnix: a.UpdatedUnix,
+	}
+}
+
+// apiFail responds with a JSON error.
+func apiFail(ctx *emmanuel.Context, status int, msg string) {
+	ctx.JSON(status, apiError{Error: msg})
+}
+
+// apiPaging reads the requested page and page size from the query string,
+// falling back to sensible defaults.
+func apiPaging(ctx *emmanuel.Context) (page, perPage int) {
+	page = ctx.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	perPage = ctx.QueryInt("per_page")
+	if perPage < 1 {
+		perPage = apiDefaultPerPage
+	} else if perPage > apiMaxPerPage {
+		perPage = apiMaxPerPage
+	}
+	return
+}
+
+func newAPIPagination(page, perPage int, total int64) apiPagination {
+	return apiPagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + int64(perPage) - 1) / int64(perPage),
+	}
+}
+
+// errNotJSON is returned when a write request isn't declared as JSON.
+// Requiring JSON also keeps plain cross-site form posts out of the API.
+var errNotJSON = errors.New("Request body must be JSON")
+
+// isJSONRequest checks whether a request is declared to carry JSON.
+func isJSONRequest(ctx *emmanuel.Context) bool {
+	mt, _, err := mime.ParseMediaType(ctx.Req.Header.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
+
+// decodeAPIBody decodes the JSON body of a request into v.
+func decodeAPIBody(ctx *emmanuel.Context, v interface{}) error {
+	if !isJSONRequest(ctx) {
+		return errNotJSON
+	}
+	if err := json.NewDecoder(ctx.Req.Body().ReadCloser()).Decode(v); err != nil {
+		return errors.New("Malformed JSON body")
+	}
+	return nil
+}
+
+// APIRequireJSON responds with an error to write requests which aren't
+// declared as JSON, even those without a body such as upvotes. Browsers can't
+// send JSON across sites without the API allowing it, so this stops other
+// sites from acting with a visitor's session cookie.
+func APIRequireJSON(ctx *emmanuel.Context) {
+	switch ctx.Req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	if !isJSONRequest(ctx) {
+		apiFail(ctx, http.StatusUnsupportedMediaType, errNotJSON.Error())
+	}
+}
+
+// APIRequireAdmin responds with an error if user is not an administrator.
+func APIRequireAdmin(ctx *emmanuel.Context, sess session.Store) {
+	if !(sess.Get("auth") == LoggedIn && sess.Get("isadmin") == 1) {
+		apiFail(ctx, http.StatusForbidden, "Administrator access required")
+		return
+	}
+}
+
+// APINotFoundHandler response for unknown API endpoints.
+func APINotFoundHandler(ctx *emmanuel.Context) {
+	apiFail(ctx, http.StatusNotFound, "Unknown endpoint")
+}
+
+// APITicketsHandler response for listing tickets.
+func APITicketsHandler(ctx *emmanuel.Context) {
+	filter, err := ticketFilter(ctx.QueryTrim("category"), ctx.QueryTrim("degree"),
+		ctx.QueryTrim("status"), ctx.QueryTrim("from"), ctx.QueryTrim("to"), "all")
+	if err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	by, err := ticketSort(ctx.QueryTrim("sort"))
+	if err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Archived = ctx.QueryBool("archived")
+	filter.Unanswered = ctx.QueryBool("unanswered")
+	page, perPage := apiPaging(ctx)
+
+	tickets := models.FindTickets(filter, by, (page-1)*perPage, perPage)
+	if err := models.LoadCommentsCounts(tickets); err != nil {
+		log.Println(err)
+	}
+	if err := models.LoadLabels(tickets); err != nil {
+		log.Println(err)
+	}
+	data := make([]apiTicket, 0, len(tickets))
+	for i := range tickets {
+		data = append(data, newAPITicket(&tickets[i]))
+	}
+
+	ctx.JSON(http.StatusOK, apiList{
+		Data:       data,
+		Pagination: newAPIPagination(page, perPage, models.CountTickets(filter)),
+	})
+}
+
+// APITicketHandler response for a specific ticket.
+func APITicketHandler(ctx *emmanuel.Context, sess session.Store) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
+
+// APIPostTicketHandler response for posting a new ticket.
+func APIPostTicketHandler(ctx *emmanuel.Context, sess session.Store) {
+	var body struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Category    string `json:"category"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	title := strings.TrimFunc(strings.TrimSpace(body.Title), IsImproperChar)
+	text := strings.TrimFunc(strings.TrimSpace(body.Description), IsImproperChar)
+	category := strings.TrimSpace(body.Category)
+	if err := validateTicket(title, text, category); err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := checkRateLimit(ctx, sess, models.ActionTicket); err != nil {
+		apiFail(ctx, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	reason, err := filterContent(sess, title, text)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	ticket := models.Ticket{
+		Title:       title,
+		Description: text,
+		Category:    category,
+	}
+	err = holdNewTicket(&ticket, sess, reason)
+	if err == nil {
+		err = addTicket(&ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")))
+	}
+	if err != nil {
+		log.Println(err)
+		apiFail(ctx, http.StatusInternalServerError, "Failed to add ticket")
+		return
+	}
+	recordRateLimit(ctx, sess, models.ActionTicket)
+	t := newAPITicket(&ticket)
+	if ticket.IsPending {
+		t.PrivateURL = siteURL(privateTicketURL(&ticket))
+	}
+	ctx.JSON(http.StatusCreated, t)
+}
+
+// APIUpvoteTicketHandler response for upvoting a specific ticket.
+func APIUpvoteTicketHandler(ctx *emmanuel.Context, sess session.Store) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+	if ticket.DuplicateOf != 0 {
+		apiFail(ctx, http.StatusConflict, fmt.Sprintf("Ticket was merged into ticket %d", ticket.DuplicateOf))
+		return
+	}
+	if ticket.Status.IsClosed() {
+		apiFail(ctx, http.StatusConflict, "Closed tickets cannot be upvoted")
+		return
+	}
+	if ticket.IsArchived() {
+		apiFail(ctx, http.StatusConflict, "Archived tickets cannot be upvoted")
+		return
+	}
+	if ticket.IsLocked {
+		apiFail(ctx, http.StatusConflict, "Locked tickets cannot be upvoted")
+		return
+	}
+	if err = checkRateLimit(ctx, sess, models.ActionVote); err != nil {
+		apiFail(ctx, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	added, err := models.AddVote(ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")))
+	if err != nil {
+		log.Println(err)
+		apiFail(ctx, http.StatusInternalServerError, "Failed to upvote ticket")
+		return
+	}
+	if added {
+		recordRateLimit(ctx, sess, models.ActionVote)
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
+
+// APIRetractVoteHandler response for retracting the upvote of a ticket.
+func APIRetractVoteHandler(ctx *emmanuel.Context, sess session.Store) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+	if ticket.DuplicateOf != 0 {
+		apiFail(ctx, http.StatusConflict, fmt.Sprintf("Ticket was merged into ticket %d", ticket.DuplicateOf))
+		return
+	}
+	if ticket.Status.IsClosed() {
+		apiFail(ctx, http.StatusConflict, "Votes on closed tickets cannot be retracted")
+		return
+	}
+	if ticket.IsArchived() {
+		apiFail(ctx, http.StatusConflict, "Votes on archived tickets cannot be retracted")
+		return
+	}
+	if ticket.IsLocked {
+		apiFail(ctx, http.StatusConflict, "Votes on locked tickets cannot be retracted")
+		return
+	}
+
+	_, err = models.RemoveVote(ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")))
+	if err != nil {
+		log.Println(err)
+		apiFail(ctx, http.StatusInternalServerError, "Failed to retract vote")
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
+
+// APICommentsHandler response for listing the comments of a ticket.
+func APICommentsHandler(ctx *emmanuel.Context, sess session.Store) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+	page, perPage := apiPaging(ctx)
+
+	comments := models.GetCommentsRange(ticket.TicketID, (page-1)*perPage, perPage)
+	models.MarkPosterComments(ticket.TicketID, comments)
+	data := make([]apiComment, 0, len(comments))
+	for i := range comments {
+		data = append(data, newAPIComment(&comments[i]))
+	}
+
+	ctx.JSON(http.StatusOK, apiList{
+		Data:       data,
+		Pagination: newAPIPagination(page, perPage, models.CountComments(ticket.TicketID)),
+	})
+}
+
+// APIPostCommentHandler response for posting a new comment on a ticket.
+func APIPostCommentHandler(ctx *emmanuel.Context, sess session.Store) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+	if ticket.DuplicateOf != 0 {
+		apiFail(ctx, http.StatusConflict, fmt.Sprintf("Ticket was merged into ticket %d", ticket.DuplicateOf))
+		return
+	}
+	if ticket.IsArchived() {
+		apiFail(ctx, http.StatusConflict, "Archived tickets cannot be commented on")
+		return
+	}
+
+	var body struct {
+		Text     string `json:"text"`
+		AsAdmin  bool   `json:"as_admin"`
+		ParentID int64  `json:"parent_id"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	text := strings.TrimFunc(strings.TrimSpace(body.Text), IsImproperChar)
+	if len(text) == 0 {
+		apiFail(ctx, http.StatusUnprocessableEntity, "Comment cannot be empty!")
+		return
+	}
+
+	comment := models.Comment{
+		TicketID: ticket.TicketID,
+		Text:     text,
+	}
+	if body.ParentID != 0 {
+		parent, err := models.GetComment(body.ParentID)
+		if err != nil || parent.TicketID != ticket.TicketID || parent.IsDeleted || parent.IsPending {
+			apiFail(ctx, http.StatusUnprocessableEntity, "Parent comment not found")
+			return
+		}
+		comment.ReplyTo(parent)
+	}
+	if body.AsAdmin {
+		if sess.Get("isadmin") != 1 {
+			apiFail(ctx, http.StatusForbidden, "Administrator access required")
+			return
+		}
+		comment.IsAdmin = true
+		comment.PosterID = ctx.Data["User"].(config.ClassRepresentative).Name
+	}
+	if ticket.IsLocked && sess.Get("isadmin") != 1 {
+		apiFail(ctx, http.StatusConflict, "Locked tickets cannot be commented on")
+		return
+	}
+	if err = checkRateLimit(ctx, sess, models.ActionComment); err != nil {
+		apiFail(ctx, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if comment.PendingReason, err = filterContent(sess, "", text); err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	comment.IsPending = comment.PendingReason != ""
+
+	if err := addComment(&comment, ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent"))); err != nil {
+		log.Println(err)
+		apiFail(ctx, http.StatusInternalServerError, "Failed to add comment")
+		return
+	}
+	recordRateLimit(ctx, sess, models.ActionComment)
+	posted := []models.Comment{comment}
+	models.MarkPosterComments(ticket.TicketID, posted)
+	ctx.JSON(http.StatusCreated, newAPIComment(&posted[0]))
+}
+
+// APIAnnouncementsHandler response for listing announcements.
+func APIAnnouncementsHandler(ctx *emmanuel.Context) {
+	page, perPage := apiPaging(ctx)
+
+	announcements := models.GetAnnouncementsRange((page-1)*perPage, perPage)
+	data := make([]apiAnnouncement, 0, len(announcements))
+	for i := range announcements {
+		data = append(data, newAPIAnnouncement(&announcements[i]))
+	}
+
+	ctx.JSON(http.StatusOK, apiList{
+		Data:       data,
+		Pagination: newAPIPagination(page, perPage, models.CountAnnouncements()),
+	})
+}
+
+// APIAnnouncementHandler response for a specific announcement.
+func APIAnnouncementHandler(ctx *emmanuel.Context) {
+	announcement, err := models.GetAnnouncement(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Announcement not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPIAnnouncement(announcement))
+}
+
+// APIPostAnnouncementHandler response for posting a new announcement.
+func APIPostAnnouncementHandler(ctx *emmanuel.Context) {
+	var body struct {
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	title := strings.TrimFunc(strings.TrimSpace(body.Title), IsImproperChar)
+	text := strings.TrimFunc(strings.TrimSpace(body.Description), IsImproperChar)
+	if len(title) == 0 || len(text) == 0 {
+		apiFail(ctx, http.StatusUnprocessableEntity, "Title or body cannot be empty!")
+		return
+	}
+
+	announcement := models.Announcement{
+		Title:       title,
+		Description: text,
+		Tags:        strings.Join(body.Tags, ","),
+	}
+	err := addAnnouncement(&announcement, ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		log.Println(err)
+		apiFail(ctx, http.StatusInternalServerError, "Failed to add announcement")
+		return
+	}
+	ctx.JSON(http.StatusCreated, newAPIAnnouncement(&announcement))
+}
+
+// APITicketStatusHandler response for changing the status of a ticket.
+func APITicketStatusHandler(ctx *emmanuel.Context) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	var body struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = changeTicketStatus(ticket, models.TicketStatus(strings.TrimSpace(body.Status)),
+		strings.TrimSpace(body.Note), ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
+
+// APIDuplicateTicketHandler response for merging a ticket into another ticket
+// it duplicates.
+func APIDuplicateTicketHandler(ctx *emmanuel.Context) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	var body struct {
+		Of     int64  `json:"of"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	canonical, err := models.GetTicket(body.Of)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, "Ticket to merge into not found")
+		return
+	}
+
+	err = mergeTicket(ticket, canonical, strings.TrimSpace(body.Reason),
+		ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(canonical))
+}
+
+// APIPinTicketHandler response for pinning or unpinning a ticket.
+func APIPinTicketHandler(ctx *emmanuel.Context) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	var body struct {
+		Pinned bool   `json:"pinned"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = pinTicket(ticket, body.Pinned, strings.TrimSpace(body.Reason),
+		ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
+
+// APILockTicketHandler response for locking or unlocking a ticket.
+func APILockTicketHandler(ctx *emmanuel.Context) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	var body struct {
+		Locked bool   `json:"locked"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = lockTicket(ticket, body.Locked, strings.TrimSpace(body.Reason),
+		ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
+
+// APIArchiveTicketHandler response for archiving or restoring a ticket.
+func APIArchiveTicketHandler(ctx *emmanuel.Context) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	var body struct {
+		Archived bool   `json:"archived"`
+		Reason   string `json:"reason"`
+	}
+	if err := decodeAPIBody(ctx, &body); err != nil {
+		apiFail(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = archiveTicket(ticket, body.Archived, strings.TrimSpace(body.Reason),
+		ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		apiFail(ctx, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, newAPITicketWithCount(ticket))
+}
+
+// APIDeleteTicketHandler response for deleting a ticket.
+func APIDeleteTicketHandler(ctx *emmanuel.Context) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	deleteTicket(ticket, ctx.Data["User"].(config.ClassRepresentative).Name)
+	ctx.Status(http.StatusNoContent)
+}
+
+// APIDeleteCommentHandler response for deleting a ticket's comment.
+func APIDeleteCommentHandler(ctx *emmanuel.Context) {
+	comment, err := models.GetComment(ctx.ParamsInt64("cid"))
+	if err != nil || comment.TicketID != ctx.ParamsInt64("id") {
+		apiFail(ctx, http.StatusNotFound, "Comment not found")
+		return
+	}
+	ticket, err := models.GetTicket(comment.TicketID)
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	deleteComment(comment, ticket, ctx.Data["User"].(config.ClassRepresentative).Name)
+	ctx.Status(http.StatusNoContent)
+}
+
+// APIDeleteAnnouncementHandler response for deleting an announcement.
+func APIDeleteAnnouncementHandler(ctx *emmanuel.Context) {
+	announcement, err := models.GetAnnouncement(ctx.ParamsInt64("id"))
+	if err != nil {
+		apiFail(ctx, http.StatusNotFound, "Announcement not found")
+		return
+	}
+
+	deleteAnnouncement(announcement, ctx.Data["User"].(config.ClassRepresentative).Name)
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-emmanuel/csrf"
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+// archiveInterval is how often tickets are checked for archiving.
+const archiveInterval = time.Hour
+
+var errTicketArchived = errors.New("This ticket is archived and can no longer be changed!")
+
+// archiveTicket archives or restores a ticket and logs it as done by the
+// admin.
+func archiveTicket(ticket *models.Ticket, archived bool, reason, admin string) error {
+	if ticket.IsArchived() == archived {
+		if archived {
+			return errors.New("The ticket is already archived!")
+		}
+		return errors.New("The ticket is not archived!")
+	}
+	if err := models.SetTicketArchived(ticket, archived); err != nil {
+		return err
+	}
+
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Restored from the archive",
+		Reason:      reason,
+	}
+	if archived {
+		m.Description = "Archived, making it read-only"
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// archiveTickets archives the tickets which have gone without votes or
+// comments, and closes the tickets which have been resolved, for the number of
+// days in the configuration.
+func archiveTickets() {
+	now := time.Now()
+	if days := config.Config.Archive.StaleDays; days > 0 {
+		tickets := models.FindStaleTickets(now.AddDate(0, 0, -days).Unix())
+		for i := range tickets {
+			err := archiveTicket(&tickets[i], true,
+				fmt.Sprintf("No votes or comments for %d days", days), models.SystemActor)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	if days := config.Config.Archive.ResolvedDays; days > 0 {
+		tickets := models.FindResolvedTickets(now.AddDate(0, 0, -days).Unix())
+		for i := range tickets {
+			err := archiveTicket(&tickets[i], true,
+				fmt.Sprintf("Closed after being resolved for %d days", days), models.SystemActor)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// StartArchiver archives tickets in the background, when started and then
+// every archiveInterval.
+func StartArchiver() {
+	go func() {
+		for {
+			archiveTickets()
+			time.Sleep(archiveInterval)
+		}
+	}()
+}
+
+// ArchivedTicketsHandler response for the archive of tickets.
+func ArchivedTicketsHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash, x csrf.CSRF) {
+	filter, err := ticketFilter("", "", ctx.Query("status"), ctx.Query("from"), ctx.Query("to"), "all")
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets/archive")
+		return
+	}
+	filter.Archived = true
+	filter.Unanswered = ctx.QueryBool("unanswered")
+	by, err := ticketSort(ctx.Query("sort"))
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets/archive")
+		return
+	}
+
+	ctx.Data["Title"] = "Archived Tickets"
+	ctx.Data["IsArchive"] = 1
+	renderTickets(ctx, filter, by, x)
+}
+
+// PostTicketArchiveHandler response for archiving or restoring a ticket.
+func PostTicketArchiveHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+
+	archived := ctx.QueryBool("archived")
+	err = archiveTicket(ticket, archived, ctx.QueryTrim("reason"), ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		f.Error(err.Error())
+	} else if archived {
+		f.Success("Ticket archived!")
+	} else {
+		f.Success("Ticket restored from the archive!")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
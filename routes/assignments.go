@@ -0,0 +1,144 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-emmanuel/csrf"
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+var errNotClassRep = errors.New("Tickets can only be assigned to class representatives")
+
+// repAssignment is a class representative, and whether they are assigned to a
+// ticket.
+type repAssignment struct {
+	config.ClassRepresentative
+	Assigned bool
+}
+
+// getClassRepsByEmails returns the class representatives with the email
+// addresses, leaving out addresses which are no longer in the configuration.
+func getClassRepsByEmails(emails []string) (reps []config.ClassRepresentative) {
+	for _, e := range emails {
+		for _, c := range config.Config.InstanceConfig.ClassReps {
+			if c.Email == e {
+				reps = append(reps, c)
+				break
+			}
+		}
+	}
+	return
+}
+
+// getRepAssignments returns every class representative, marking those which
+// are assigned to the ticket.
+func getRepAssignments(assignees []string) (reps []repAssignment) {
+	assigned := make(map[string]bool, len(assignees))
+	for _, e := range assignees {
+		assigned[e] = true
+	}
+	for _, c := range config.Config.InstanceConfig.ClassReps {
+		reps = append(reps, repAssignment{c, assigned[c.Email]})
+	}
+	return
+}
+
+// repNames lists the names of class representatives for the moderation log.
+func repNames(reps []config.ClassRepresentative) string {
+	if len(reps) == 0 {
+		return "nobody"
+	}
+	names := make([]string, len(reps))
+	for i, c := range reps {
+		names[i] = c.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// autoAssignTicket assigns a new ticket to the class representatives of the
+// degrees which take its course, like complaints are sent to them.
+func autoAssignTicket(ticket *models.Ticket) error {
+	var emails []string
+	for _, c := range getClassRepsByCourseCode(ticket.Category) {
+		emails = append(emails, c.Email)
+	}
+	if len(emails) == 0 {
+		return nil
+	}
+	return models.AssignTicket(ticket.TicketID, emails)
+}
+
+// assignTicket replaces the class representatives assigned to a ticket and
+// logs it as done by the admin.
+func assignTicket(ticket *models.Ticket, emails []string, reason, admin string) error {
+	if ticket.IsArchived() {
+		return errTicketArchived
+	}
+	to := getClassRepsByEmails(emails)
+	if len(to) != len(emails) {
+		return errNotClassRep
+	}
+	from := getClassRepsByEmails(models.GetAssignees(ticket.TicketID))
+
+	if err := models.AssignTicket(ticket.TicketID, emails); err != nil {
+		return err
+	}
+
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Reassigned from " + repNames(from) + " to " + repNames(to),
+		Reason:      reason,
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostTicketAssignHandler response for changing the class representatives
+// assigned to a ticket.
+func PostTicketAssignHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+
+	err = assignTicket(ticket, ctx.QueryStrings("assignee"), ctx.QueryTrim("reason"),
+		ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		f.Error(err.Error())
+	} else {
+		f.Success("Assignees updated!")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
+
+// AssignedTicketsHandler response for the tickets assigned to the logged in
+// class representative.
+func AssignedTicketsHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash, x csrf.CSRF) {
+	filter, err := ticketFilter("", "", ctx.Query("status"), ctx.Query("from"), ctx.Query("to"), "active")
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets/assigned")
+		return
+	}
+	filter.Assignee = ctx.Data["User"].(config.ClassRepresentative).Email
+	filter.Unanswered = ctx.QueryBool("unanswered")
+	by, err := ticketSort(ctx.Query("sort"))
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets/assigned")
+		return
+	}
+
+	ctx.Data["Title"] = "Assigned Tickets"
+	ctx.Data["IsAssigned"] = 1
+	renderTickets(ctx, filter, by, x)
+}
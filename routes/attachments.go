@@ -0,0 +1,419 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+const (
+	// defaultAttachmentMaxSize is used if the configuration has no limit.
+	defaultAttachmentMaxSize = 5 << 20
+	// defaultAttachmentMaxFiles is used if the configuration has no limit.
+	defaultAttachmentMaxFiles = 4
+	// maxImagePixels limits the size of decoded images, so small files can't
+	// expand into huge images.
+	maxImagePixels = 40000000
+	// maxGIFFrames limits the frames of animated images.
+	maxGIFFrames = 500
+)
+
+var (
+	// ticketAttachmentTypes are the types students can attach to tickets.
+	// Only images are allowed, as their metadata can be stripped.
+	ticketAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif"}
+	// announcementAttachmentTypes are the types reps can attach to
+	// announcements.
+	announcementAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "application/pdf"}
+
+	errTooManyAttachments = errors.New("Too many files attached!")
+	errAttachmentTooLarge = errors.New("An attached file is too large!")
+	errAttachmentType     = errors.New("An attached file is of an unsupported type!")
+	errImageTooLarge      = errors.New("An attached image has too many pixels!")
+)
+
+func attachmentsPath() string {
+	if config.Config.Attachments.Path == "" {
+		return "attachments"
+	}
+	return config.Config.Attachments.Path
+}
+
+func attachmentMaxSize() int64 {
+	if config.Config.Attachments.MaxSize <= 0 {
+		return defaultAttachmentMaxSize
+	}
+	return config.Config.Attachments.MaxSize
+}
+
+func attachmentMaxFiles() int {
+	if config.Config.Attachments.MaxFiles <= 0 {
+		return defaultAttachmentMaxFiles
+	}
+	return config.Config.Attachments.MaxFiles
+}
+
+// setAttachmentLimits shows the attachment limits on a posting form.
+func setAttachmentLimits(ctx *emmanuel.Context) {
+	ctx.Data["MaxAttachments"] = attachmentMaxFiles()
+	if size := attachmentMaxSize(); size >= 1<<20 {
+		ctx.Data["MaxAttachmentSize"] = fmt.Sprintf("%d MB", size>>20)
+	} else {
+		ctx.Data["MaxAttachmentSize"] = fmt.Sprintf("%d KB", size>>10)
+	}
+}
+
+// attachmentFile returns where the file with the hash is stored.
+func attachmentFile(hash string) string {
+	return filepath.Join(attachmentsPath(), hash[:2], hash)
+}
+
+// LimitUploads is a middleware which rejects requests larger than the files
+// which may be attached to a post, before they are read.
+func LimitUploads(ctx *emmanuel.Context, f *session.Flash) {
+	limit := attachmentMaxSize()*int64(attachmentMaxFiles()) + 1<<20
+	if ctx.Req.ContentLength > limit {
+		f.Error(errAttachmentTooLarge.Error())
+		ctx.Redirect(ctx.Req.URL.Path)
+		return
+	}
+	ctx.Req.Request.Body = http.MaxBytesReader(ctx.Resp, ctx.Req.Request.Body, limit)
+}
+
+func isAllowedType(contentType string, allowed []string) bool {
+	for _, t := range allowed {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// countGIFFrames counts the frames of a GIF image without decoding them, by
+// skipping over the blocks of the file.
+func countGIFFrames(data []byte) (int, error) {
+	// The header and logical screen descriptor take 13 bytes, followed by the
+	// global colour table if there is one.
+	if len(data) < 13 {
+		return 0, errAttachmentType
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skipSubBlocks skips a sequence of data sub-blocks ending with an empty
+	// one.
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos += n + 1
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			pos += 2
+			if !skipSubBlocks() {
+				return 0, errAttachmentType
+			}
+		case 0x2c: // image descriptor, followed by the image data
+			if pos+10 > len(data) {
+				return 0, errAttachmentType
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++ // LZW minimum code size
+			if !skipSubBlocks() {
+				return 0, errAttachmentType
+			}
+			frames++
+		case 0x3b: // trailer
+			return frames, nil
+		default:
+			return 0, errAttachmentType
+		}
+	}
+	return 0, errAttachmentType
+}
+
+// stripImage re-encodes an image, which drops its metadata such as the EXIF
+// location and camera details.
+func stripImage(data []byte, contentType string) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errAttachmentType
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errAttachmentType
+		}
+		if err = png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errAttachmentType
+		}
+		if err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+	case "image/gif":
+		// Every frame is decoded into an image up to the size of the whole
+		// image, so the frames are counted first.
+		frames, err := countGIFFrames(data)
+		if err != nil {
+			return nil, err
+		}
+		if frames > maxGIFFrames || frames*cfg.Width*cfg.Height > maxImagePixels {
+			return nil, errImageTooLarge
+		}
+		img, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, errAttachmentType
+		}
+		if err = gif.EncodeAll(&buf, img); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errAttachmentType
+	}
+	return buf.Bytes(), nil
+}
+
+// saveAttachment validates and stores an uploaded file.
+func saveAttachment(fh *multipart.FileHeader, allowed []string) (*models.Attachment, error) {
+	if fh.Size > attachmentMaxSize() {
+		return nil, errAttachmentTooLarge
+	}
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, attachmentMaxSize()+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > attachmentMaxSize() {
+		return nil, errAttachmentTooLarge
+	}
+
+	// The type is detected from the content, as the client can claim any.
+	contentType := http.DetectContentType(data)
+	if !isAllowedType(contentType, allowed) {
+		return nil, errAttachmentType
+	}
+	if strings.HasPrefix(contentType, "image/") {
+		if data, err = stripImage(data, contentType); err != nil {
+			return nil, err
+		}
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	path := attachmentFile(hash)
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		// Written to a temporary file first, so a partial file is never served.
+		tmp, err := ioutil.TempFile(filepath.Dir(path), hash+".tmp")
+		if err != nil {
+			return nil, err
+		}
+		_, err = tmp.Write(data)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+
+	return models.AddAttachment(&models.Attachment{
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	})
+}
+
+// saveAttachments stores the files uploaded with a post, and returns the
+// Markdown which embeds them in it and their hashes, to record the post as
+// their owner once it is added. Images are shown inline and link to the full
+// size image, and other files are linked.
+func saveAttachments(ctx *emmanuel.Context, allowed []string) (string, []string, error) {
+	if err := ctx.Req.ParseMultipartForm(emmanuel.MaxMemory); err == http.ErrNotMultipart {
+		return "", nil, nil // the form was sent without files
+	} else if err != nil {
+		return "", nil, errAttachmentTooLarge
+	}
+	files := ctx.Req.MultipartForm.File["attachments"]
+	if len(files) > attachmentMaxFiles() {
+		return "", nil, errTooManyAttachments
+	}
+
+	var md strings.Builder
+	var hashes []string
+	added := make(map[string]bool)
+	for _, fh := range files {
+		if fh.Size == 0 && fh.Filename == "" {
+			continue // no file was chosen
+		}
+		a, err := saveAttachment(fh, allowed)
+		if err != nil {
+			return "", nil, err
+		}
+		if added[a.Hash] {
+			continue
+		}
+		added[a.Hash] = true
+		hashes = append(hashes, a.Hash)
+		// File names aren't kept, as they could identify the poster.
+		name := fmt.Sprintf("Attachment %d", len(added))
+		if strings.HasPrefix(a.ContentType, "image/") {
+			md.WriteString(fmt.Sprintf("\n\n[![%s](/attachments/%s)](/attachments/%s)", name, a.Hash, a.Hash))
+		} else {
+			md.WriteString(fmt.Sprintf("\n\n[%s (%s)](/attachments/%s)", name,
+				strings.TrimPrefix(a.ContentType, "application/"), a.Hash))
+		}
+	}
+	return md.String(), hashes, nil
+}
+
+// removeUnusedAttachments deletes the attachments which are no longer owned by
+// any post, along with their files.
+func removeUnusedAttachments() {
+	hashes, err := models.DeleteUnusedAttachments()
+	if err != nil {
+		log.Println(err)
+	}
+	for _, hash := range hashes {
+		if err := os.Remove(attachmentFile(hash)); err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+	}
+}
+
+// canSeeAttachment checks whether the user can see one of the posts an
+// attachment was uploaded with, and whether everyone can.
+func canSeeAttachment(hash string, sess session.Store) (visible, public bool) {
+	for _, o := range models.GetAttachmentOwners(hash) {
+		if o.AnnouncementID != 0 {
+			if _, err := models.GetAnnouncement(o.AnnouncementID); err == nil {
+				return true, true
+			}
+			continue
+		}
+		ticket, err := models.GetTicket(o.TicketID)
+		if err != nil {
+			continue
+		}
+		if !ticket.IsPending {
+			return true, true
+		}
+		if !isHiddenTicket(ticket, sess) {
+			visible = true
+		}
+	}
+	return visible, false
+}
+
+// isAttachmentHash checks whether a string is a hash of an attachment, so it
+// is safe to use in a path.
+func isAttachmentHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// AttachmentHandler response for serving an attached file. Attachments are
+// only served while a post they were uploaded with can be seen.
+func AttachmentHandler(ctx *emmanuel.Context, sess session.Store) {
+	hash := ctx.Params("hash")
+	if !isAttachmentHash(hash) {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	visible, public := canSeeAttachment(hash, sess)
+	if !visible {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	a, err := models.GetAttachment(hash)
+	if err != nil {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(attachmentFile(hash))
+	if err != nil {
+		log.Println(err)
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	h := ctx.Resp.Header()
+	h.Set("Content-Type", a.ContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if public {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		// Attachments of posts waiting for moderation are only shown to
+		// representatives, so they mustn't be kept by shared caches.
+		h.Set("Cache-Control", "private, no-store")
+	}
+	if strings.HasPrefix(a.ContentType, "image/") {
+		h.Set("Content-Disposition", "inline")
+	} else {
+		h.Set("Content-Disposition", "attachment")
+	}
+	http.ServeContent(ctx.Resp, ctx.Req.Request, "", time.Unix(a.CreatedUnix, 0), file)
+}
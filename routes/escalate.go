@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/mailer"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+var (
+	errNoLecturers  = errors.New("Please choose at least one lecturer of the course!")
+	errNotLecturer  = errors.New("Tickets can only be escalated to lecturers of their course!")
+	errEscalateMail = errors.New("There was an error emailing the lecturers!")
+)
+
+// getLecturersByCourseCode returns the lecturers who teach the course.
+func getLecturersByCourseCode(code string) (lecturers []config.Lecturer) {
+	for _, l := range config.Config.InstanceConfig.Lecturers {
+		for _, c := range l.Courses {
+			if c == code {
+				lecturers = append(lecturers, l)
+				break
+			}
+		}
+	}
+	return
+}
+
+// getCourseName returns the name of the course with the code, or the code if
+// it isn't a course.
+func getCourseName(code string) string {
+	for _, c := range config.Config.InstanceConfig.Courses {
+		if c.Code == code {
+			return c.Name + " (" + c.Code + ")"
+		}
+	}
+	return code
+}
+
+// escalationSummary formats a ticket, its upvotes and the comments of the
+// class representatives for an email to lecturers.
+func escalationSummary(ticket *models.Ticket, note string, rep config.ClassRepresentative) string {
+	var str strings.Builder
+	str.WriteString("Hello!\n\n" + rep.Name + " (class representative) has escalated a ticket raised by students on " +
+		config.Config.SiteName + " to you.\n")
+	if note != "" {
+		str.WriteString("\n" + note + "\n")
+	}
+
+	str.WriteString("\n---\n\n" + ticket.Title + "\n\n")
+	str.WriteString("Course: " + getCourseName(ticket.Category) + "\n")
+	str.WriteString("Status: " + ticket.Status.Name() + "\n")
+	str.WriteString(fmt.Sprintf("Upvotes: %d\n", ticket.VoteCount))
+	str.WriteString("Posted: " + time.Unix(ticket.CreatedUnix, 0).Format("Jan 2 2006") + "\n\n")
+	str.WriteString(ticket.Description + "\n")
+
+	var comments []models.Comment
+	for _, c := range ticket.Comments {
+		if c.IsAdmin && !c.IsDeleted {
+			comments = append(comments, c)
+		}
+	}
+	if len(comments) > 0 {
+		str.WriteString("\nComments from the class representatives:\n")
+		for _, c := range comments {
+			str.WriteString("\n" + c.PosterID + ", " + time.Unix(c.CreatedUnix, 0).Format("Jan 2 2006") + ":\n" +
+				c.Text + "\n")
+		}
+	}
+
+	str.WriteString("\n---\n\nView the ticket at " + siteURL(fmt.Sprintf("/tickets/%d", ticket.TicketID)) + "\n")
+	str.WriteString("Please reply to " + rep.Name + " at " + rep.Email + ", as this message is sent from an unmonitored inbox.\n\n\n")
+	str.WriteString("- " + config.Config.SiteName)
+	return str.String()
+}
+
+// escalateTicket emails a ticket to lecturers of its course, records it, and
+// logs it as done by the admin. In development mode the email is only logged.
+func escalateTicket(ticket *models.Ticket, emails []string, note string, rep config.ClassRepresentative) error {
+	if ticket.IsArchived() {
+		return errTicketArchived
+	}
+	if len(emails) == 0 {
+		return errNoLecturers
+	}
+	lecturers := getLecturersByCourseCode(ticket.Category)
+	var to, names []string
+	for _, e := range emails {
+		found := false
+		for _, l := range lecturers {
+			if l.Email == e {
+				to = append(to, l.Email)
+				names = append(names, l.Name)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errNotLecturer
+		}
+	}
+
+	if err := ticket.LoadComments(); err != nil {
+		return err
+	}
+	summary := escalationSummary(ticket, note, rep)
+	if config.Config.DevMode {
+		log.Printf("Not emailing the escalation of ticket %d to %s in development mode:\n%s\n",
+			ticket.TicketID, strings.Join(to, ", "), summary)
+	} else if err := mailer.Email(to, "Student ticket: "+ticket.Title, summary); err != nil {
+		log.Println(err)
+		return errEscalateMail
+	}
+
+	err := models.AddEscalation(ticket, &models.Escalation{
+		Admin:      rep.Name,
+		Recipients: strings.Join(names, ", "),
+		Note:       note,
+	})
+	if err != nil {
+		return err
+	}
+
+	m := models.Moderation{
+		Admin:       rep.Name,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Escalated to " + strings.Join(names, ", "),
+		Reason:      note,
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostTicketEscalateHandler response for escalating a ticket to lecturers.
+func PostTicketEscalateHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+
+	err = escalateTicket(ticket, ctx.QueryStrings("lecturer"), ctx.QueryTrim("note"),
+		ctx.Data["User"].(config.ClassRepresentative))
+	if err != nil {
+		f.Error(err.Error())
+	} else {
+		f.Success("Ticket escalated to the lecturers!")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
@@ -0,0 +1,327 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+// ExportFormat is a file format tickets can be exported to.
+type ExportFormat string
+
+const (
+	// ExportCSV is a spreadsheet with a row for each ticket.
+	ExportCSV ExportFormat = "csv"
+	// ExportJSON is a list of tickets for other programs.
+	ExportJSON ExportFormat = "json"
+	// ExportMarkdown is a report to read or paste into meeting documents.
+	ExportMarkdown ExportFormat = "md"
+)
+
+// ExportFormats are all the formats tickets can be exported to.
+var ExportFormats = []ExportFormat{ExportCSV, ExportJSON, ExportMarkdown}
+
+// IsValid checks whether the format is one of ExportFormats.
+func (f ExportFormat) IsValid() bool {
+	for _, v := range ExportFormats {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the human readable name of the format.
+func (f ExportFormat) Name() string {
+	switch f {
+	case ExportCSV:
+		return "CSV"
+	case ExportJSON:
+		return "JSON"
+	case ExportMarkdown:
+		return "Markdown"
+	}
+	return string(f)
+}
+
+// ContentType returns the MIME type of files in the format.
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportJSON:
+		return "application/json"
+	}
+	return "text/markdown; charset=utf-8"
+}
+
+var errExportFormat = errors.New("Unknown export format")
+
+// ExportOptions are the tickets to export, filtered the same way as the
+// listings, and the format to export them to.
+type ExportOptions struct {
+	Format   ExportFormat
+	Category string
+	Degree   string
+	Status   string // Status is a status, or any active, closed or all statuses.
+	From     string // From is the first day of the date range, as YYYY-MM-DD.
+	To       string // To is the last day of the date range, as YYYY-MM-DD.
+	Archived bool   // Archived exports archived tickets instead of current ones.
+}
+
+// exportComment is a class representative's comment in an export. Students'
+// comments are never exported, so their pseudonyms can't be.
+type exportComment struct {
+	Author      string `json:"author"`
+	Text        string `json:"text"`
+	IsAnswer    bool   `json:"is_answer"`
+	CreatedUnix int64  `json:"created_unix"`
+}
+
+// exportTicket is a ticket in an export. Like the API, it leaves out the
+// voter hashes, which must never leave the server.
+type exportTicket struct {
+	ID            int64           `json:"id"`
+	URL           string          `json:"url"`
+	Title         string          `json:"title"`
+	Category      string          `json:"category"`
+	Status        string          `json:"status"`
+	Description   string          `json:"description"`
+	Upvotes       int             `json:"upvotes"`
+	CommentsCount int             `json:"comments_count"`
+	Labels        []string        `json:"labels"`
+	RepComments   []exportComment `json:"rep_comments"`
+	CreatedUnix   int64           `json:"created_unix"`
+	UpdatedUnix   int64           `json:"updated_unix"`
+}
+
+// getExportTickets fetches the tickets matching the options, most upvoted
+// first, along with their class representatives' comments.
+func getExportTickets(opts ExportOptions) ([]exportTicket, error) {
+	filter, err := ticketFilter(opts.Category, opts.Degree, opts.Status, opts.From, opts.To, "all")
+	if err != nil {
+		return nil, err
+	}
+	filter.Archived = opts.Archived
+
+	tickets := models.FindTickets(filter, models.SortVotes, 0, int(models.CountTickets(filter)))
+	// Pinned tickets aren't put first in exports.
+	sort.SliceStable(tickets, func(i, j int) bool {
+		return tickets[i].VoteCount > tickets[j].VoteCount
+	})
+	if err = models.LoadCommentsCounts(tickets); err != nil {
+		return nil, err
+	}
+	if err = models.LoadLabels(tickets); err != nil {
+		return nil, err
+	}
+
+	exported := make([]exportTicket, 0, len(tickets))
+	for i := range tickets {
+		t := &tickets[i]
+		e := exportTicket{
+			ID:            t.TicketID,
+			URL:           siteURL(fmt.Sprintf("/tickets/%d", t.TicketID)),
+			Title:         t.Title,
+			Category:      t.Category,
+			Status:        string(t.Status),
+			Description:   t.Description,
+			Upvotes:       t.VoteCount,
+			CommentsCount: t.CommentsCount,
+			Labels:        make([]string, len(t.Labels)),
+			RepComments:   []exportComment{},
+			CreatedUnix:   t.CreatedUnix,
+			UpdatedUnix:   t.UpdatedUnix,
+		}
+		for j, l := range t.Labels {
+			e.Labels[j] = l.Name
+		}
+		if err = t.LoadComments(); err != nil {
+			return nil, err
+		}
+		for _, c := range t.Comments {
+			if c.IsAdmin && !c.IsDeleted && !c.IsPending {
+				e.RepComments = append(e.RepComments, exportComment{
+					Author:      c.PosterID,
+					Text:        c.Text,
+					IsAnswer:    c.CommentID == t.AnswerID,
+					CreatedUnix: c.CreatedUnix,
+				})
+			}
+		}
+		exported = append(exported, e)
+	}
+	return exported, nil
+}
+
+// ExportTickets writes the tickets matching the options to w in the format of
+// the options.
+func ExportTickets(w io.Writer, opts ExportOptions) error {
+	if !opts.Format.IsValid() {
+		return errExportFormat
+	}
+	tickets, err := getExportTickets(opts)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Format {
+	case ExportCSV:
+		return exportCSV(w, tickets)
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tickets)
+	}
+	return exportMarkdown(w, tickets, opts)
+}
+
+// exportDate formats a time for exports.
+func exportDate(unix int64) string {
+	return time.Unix(unix, 0).Format("2006-01-02 15:04")
+}
+
+// csvCell makes text safe to put in a CSV cell. Cells starting with a formula
+// character are prefixed with a quote, so spreadsheets don't run what students
+// wrote as a formula.
+func csvCell(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+func exportCSV(w io.Writer, tickets []exportTicket) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"ID", "URL", "Title", "Category", "Status", "Upvotes", "Comments", "Labels",
+		"Created", "Updated", "Description", "Official answer", "Rep comments"})
+	for _, t := range tickets {
+		var answer string
+		var comments []string
+		for _, c := range t.RepComments {
+			if c.IsAnswer {
+				answer = c.Text
+			}
+			comments = append(comments, fmt.Sprintf("%s (%s): %s", c.Author, exportDate(c.CreatedUnix), c.Text))
+		}
+		out.Write([]string{strconv.FormatInt(t.ID, 10), t.URL, csvCell(t.Title), csvCell(t.Category),
+			models.TicketStatus(t.Status).Name(), strconv.Itoa(t.Upvotes), strconv.Itoa(t.CommentsCount),
+			csvCell(strings.Join(t.Labels, ", ")), exportDate(t.CreatedUnix), exportDate(t.UpdatedUnix),
+			csvCell(t.Description), csvCell(answer), csvCell(strings.Join(comments, "\n\n"))})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// markdownLinkText escapes text to be the text of a Markdown link, on a single
+// line.
+var markdownLinkText = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, "\r\n", " ", "\n", " ", "\r", " ")
+
+// quoteMarkdown turns text into a Markdown block quote, so that its own
+// headings don't break up the report.
+func quoteMarkdown(text string) string {
+	return "> " + strings.ReplaceAll(strings.TrimSpace(text), "\n", "\n> ")
+}
+
+func exportMarkdown(w io.Writer, tickets []exportTicket, opts ExportOptions) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s tickets\n\n", config.Config.SiteName)
+	var filters []string
+	if opts.Category != "" {
+		filters = append(filters, "course "+opts.Category)
+	} else if opts.Degree != "" {
+		filters = append(filters, "degree "+opts.Degree)
+	}
+	if opts.Status != "" && opts.Status != "all" {
+		filters = append(filters, "status "+opts.Status)
+	}
+	if opts.From != "" {
+		filters = append(filters, "from "+opts.From)
+	}
+	if opts.To != "" {
+		filters = append(filters, "to "+opts.To)
+	}
+	if opts.Archived {
+		filters = append(filters, "archived")
+	}
+	fmt.Fprintf(&b, "Exported on %s with %d tickets", time.Now().Format("Jan 2 2006"), len(tickets))
+	if len(filters) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(filters, ", "))
+	}
+	b.WriteString(", most upvoted first.\n")
+
+	for _, t := range tickets {
+		fmt.Fprintf(&b, "\n## [#%d %s](%s)\n\n", t.ID, markdownLinkText.Replace(t.Title), t.URL)
+		fmt.Fprintf(&b, "%s · %s · %d upvotes · %d comments · posted %s",
+			t.Category, models.TicketStatus(t.Status).Name(), t.Upvotes, t.CommentsCount, time.Unix(t.CreatedUnix, 0).Format("Jan 2 2006"))
+		if len(t.Labels) > 0 {
+			b.WriteString(" · " + strings.Join(t.Labels, ", "))
+		}
+		b.WriteString("\n\n" + quoteMarkdown(t.Description) + "\n")
+
+		if len(t.RepComments) > 0 {
+			b.WriteString("\n### Representatives' comments\n")
+		}
+		for _, c := range t.RepComments {
+			fmt.Fprintf(&b, "\n**%s**, %s", c.Author, time.Unix(c.CreatedUnix, 0).Format("Jan 2 2006"))
+			if c.IsAnswer {
+				b.WriteString(" (official answer)")
+			}
+			b.WriteString(":\n\n" + quoteMarkdown(c.Text) + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ExportHandler response for the page to export tickets.
+func ExportHandler(ctx *emmanuel.Context) {
+	ctx.Data["Title"] = "Export Tickets"
+	ctx.Data["Courses"] = config.Config.InstanceConfig.Courses
+	ctx.Data["LoadedDegrees"] = config.LoadedDegrees
+	ctx.Data["Statuses"] = models.TicketStatuses
+	ctx.Data["ExportFormats"] = ExportFormats
+	ctx.Data["HasScope"] = 1
+	ctx.HTML(200, "export")
+}
+
+// ExportDownloadHandler response for downloading exported tickets.
+func ExportDownloadHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	opts := ExportOptions{
+		Format:   ExportFormat(ctx.Query("format")),
+		Category: ctx.QueryTrim("category"),
+		Degree:   ctx.QueryTrim("degree"),
+		Status:   ctx.QueryTrim("status"),
+		From:     ctx.QueryTrim("from"),
+		To:       ctx.QueryTrim("to"),
+		Archived: ctx.QueryBool("archived"),
+	}
+
+	// The export is written to a buffer first, so errors can still be shown.
+	var buf bytes.Buffer
+	if err := ExportTickets(&buf, opts); err != nil {
+		log.Println(err)
+		f.Error(err.Error())
+		ctx.Redirect("/export")
+		return
+	}
+
+	filename := fmt.Sprintf("tickets-%s.%s", time.Now().Format("2006-01-02"), opts.Format)
+	ctx.Resp.Header().Set("Content-Type", opts.Format.ContentType())
+	ctx.Resp.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	ctx.Resp.Write(buf.Bytes())
+}
@@ -0,0 +1,260 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-emmanuel/csrf"
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+// linkPattern matches the start of a link in a post.
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+
+var errFiltered = errors.New("Your post was caught by the spam and abuse filter, so it was not posted. " +
+	"Please contact your class representatives if this is a mistake.")
+
+// filterContent checks the title and text of an anonymous ticket or comment
+// against the content filter. It returns why the post should be held for
+// moderation, or errFiltered if it should be rejected. Class representatives
+// are exempt.
+func filterContent(sess session.Store, title, text string) (string, error) {
+	if sess.Get("isadmin") == 1 {
+		return "", nil
+	}
+	filter := config.Config.InstanceConfig.ContentFilter
+	post := strings.TrimSpace(title + "\n" + text)
+
+	reason := ""
+	if w := filter.BlockedWord(post); w != "" {
+		reason = "Contains the blocked word \"" + w + "\""
+	} else if p := filter.BlockedPattern(post); p != "" {
+		reason = "Matches the blocked pattern \"" + p + "\""
+	}
+	if reason == "" && filter.MaxLinks > 0 {
+		if n := len(linkPattern.FindAllStringIndex(post, -1)); n > filter.MaxLinks {
+			reason = fmt.Sprintf("Has %d links, more than %d", n, filter.MaxLinks)
+		}
+	}
+	if reason == "" && filter.RepeatPeriod > 0 &&
+		models.HasRecentPost(text, time.Now().Unix()-filter.RepeatPeriod) {
+		reason = "Repeats a recent post"
+	}
+
+	if reason != "" && filter.Action == config.FilterReject {
+		log.Println("Rejected post:", reason)
+		return "", errFiltered
+	}
+	return reason, nil
+}
+
+// holdNewTicket holds a new ticket for moderation if the content filter caught
+// it, for the reason, or if pre-moderation is on and it wasn't posted by a
+// class representative. Held tickets get a token for the poster's private link.
+func holdNewTicket(ticket *models.Ticket, sess session.Store, reason string) (err error) {
+	if reason == "" && config.Config.InstanceConfig.PreModerateTickets && sess.Get("isadmin") != 1 {
+		reason = "Pre-moderation is on"
+	}
+	if reason == "" {
+		return nil
+	}
+	ticket.IsPending, ticket.PendingReason = true, reason
+	ticket.PendingToken, err = newToken()
+	return
+}
+
+// privateTicketURL returns the link which lets the poster see their ticket
+// while it is waiting for moderation.
+func privateTicketURL(ticket *models.Ticket) string {
+	return fmt.Sprintf("/tickets/%d?token=%s", ticket.TicketID, ticket.PendingToken)
+}
+
+// isHiddenTicket checks whether a ticket is hidden from the user, as it is
+// waiting for moderation.
+func isHiddenTicket(ticket *models.Ticket, sess session.Store) bool {
+	return ticket.IsPending && sess.Get("isadmin") != 1
+}
+
+// hasTicketToken checks whether the token is the one in the private link to a
+// pending ticket.
+func hasTicketToken(ticket *models.Ticket, token string) bool {
+	return ticket.PendingToken != "" && subtle.ConstantTimeCompare([]byte(ticket.PendingToken), []byte(token)) == 1
+}
+
+// visibleComments leaves out the comments waiting for moderation.
+func visibleComments(comments []models.Comment) []models.Comment {
+	visible := comments[:0]
+	for _, c := range comments {
+		if !c.IsPending {
+			visible = append(visible, c)
+		}
+	}
+	return visible
+}
+
+// queuedComment is a comment waiting for moderation, with the ticket it is on.
+type queuedComment struct {
+	models.Comment
+	TicketTitle string
+}
+
+// QueueHandler response for the tickets and comments waiting for moderation.
+func QueueHandler(ctx *emmanuel.Context, x csrf.CSRF) {
+	var comments []queuedComment
+	for _, c := range models.GetPendingComments() {
+		qc := queuedComment{Comment: c}
+		if t, err := models.GetTicket(c.TicketID); err == nil {
+			qc.TicketTitle = t.Title
+		}
+		comments = append(comments, qc)
+	}
+
+	ctx.Data["Title"] = "Moderation Queue"
+	ctx.Data["IsTickets"] = 1
+	ctx.Data["Tickets"] = models.GetPendingTickets()
+	ctx.Data["Comments"] = comments
+	ctx.Data["PreModerateTickets"] = config.Config.InstanceConfig.PreModerateTickets
+	ctx.Data["csrf_token"] = x.GetToken()
+	ctx.HTML(200, "queue")
+}
+
+// moderateTicket approves or rejects a ticket waiting for moderation, and logs
+// it as done by the admin.
+func moderateTicket(ticket *models.Ticket, approve bool, reason, admin string) error {
+	// Rejected posts were never public, so the log doesn't repeat them.
+	m := models.Moderation{
+		Admin:                admin,
+		Title:                fmt.Sprintf("Ticket #%d", ticket.TicketID),
+		DescriptionSensitive: true,
+		Reason:               reason,
+	}
+	var err error
+	if approve {
+		m.Title = "Ticket \"" + ticket.Title + "\""
+		m.Description = "Approved from the moderation queue, where it was held as: " + ticket.PendingReason
+		if err = models.ApproveTicket(ticket); err == nil {
+			err = models.ResolveReports(models.ReportTicket, ticket.TicketID)
+		}
+	} else {
+		m.Description = "Rejected \"" + ticket.Title + "\" from the moderation queue, where it was held as: " +
+			ticket.PendingReason
+		if err = models.DelTicket(ticket.TicketID); err == nil {
+			removeUnusedAttachments()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// commentLogTitle returns the title of a comment in the moderation log.
+func commentLogTitle(c *models.Comment) string {
+	title := ""
+	if t, err := models.GetTicket(c.TicketID); err == nil {
+		title = t.Title
+	}
+	return "Comment by \"" + c.PosterID + "\" on \"" + title + "\""
+}
+
+// moderateComment approves or rejects a comment waiting for moderation, and
+// logs it as done by the admin.
+func moderateComment(c *models.Comment, approve bool, reason, admin string) error {
+	m := models.Moderation{
+		Admin:                admin,
+		Title:                commentLogTitle(c),
+		DescriptionSensitive: true,
+		Reason:               reason,
+	}
+	var err error
+	if approve {
+		m.Description = "Approved from the moderation queue, where it was held as: " + c.PendingReason
+		err = models.ApproveComment(c)
+	} else {
+		m.Description = "Rejected from the moderation queue, where it was held as: " + c.PendingReason
+		err = models.DeleteComment(c.CommentID)
+	}
+	if err == nil {
+		err = models.ResolveReports(models.ReportComment, c.CommentID)
+	}
+	if err != nil {
+		return err
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostQueueTicketsHandler response for approving or rejecting the chosen
+// tickets waiting for moderation.
+func PostQueueTicketsHandler(ctx *emmanuel.Context, f *session.Flash) {
+	approve, reason := ctx.QueryBool("approve"), ctx.QueryTrim("reason")
+	admin := ctx.Data["User"].(config.ClassRepresentative).Name
+	done := 0
+	for _, id := range ctx.QueryStrings("ticket") {
+		ticketID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		ticket, err := models.GetTicket(ticketID)
+		if err != nil || !ticket.IsPending {
+			continue
+		}
+		if err = moderateTicket(ticket, approve, reason, admin); err != nil {
+			log.Println(err)
+			continue
+		}
+		done++
+	}
+
+	if done == 0 {
+		f.Error("Please choose tickets from the queue!")
+	} else if approve {
+		f.Success(fmt.Sprintf("Approved %d tickets!", done))
+	} else {
+		f.Success(fmt.Sprintf("Rejected %d tickets!", done))
+	}
+	ctx.Redirect("/queue")
+}
+
+// PostQueueCommentsHandler response for approving or rejecting the chosen
+// comments waiting for moderation.
+func PostQueueCommentsHandler(ctx *emmanuel.Context, f *session.Flash) {
+	approve, reason := ctx.QueryBool("approve"), ctx.QueryTrim("reason")
+	admin := ctx.Data["User"].(config.ClassRepresentative).Name
+	done := 0
+	for _, id := range ctx.QueryStrings("comment") {
+		commentID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		c, err := models.GetComment(commentID)
+		if err != nil || !c.IsPending {
+			continue
+		}
+		if err = moderateComment(c, approve, reason, admin); err != nil {
+			log.Println(err)
+			continue
+		}
+		done++
+	}
+
+	if done == 0 {
+		f.Error("Please choose comments from the queue!")
+	} else if approve {
+		f.Success(fmt.Sprintf("Approved %d comments!", done))
+	} else {
+		f.Success(fmt.Sprintf("Rejected %d comments!", done))
+	}
+	ctx.Redirect("/queue")
+}
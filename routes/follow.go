@@ -0,0 +1,244 @@
+package routes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/mailer"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+var errNotUniEmail = errors.New("Please enter your university email address")
+
+// followEmailCooldown is how long to wait before emailing the confirmation to
+// the same follower again, so an address can't be flooded with emails.
+const followEmailCooldown = int64(time.Hour / time.Second)
+
+// parseUniEmail validates a university email address. The domain may be left
+// out, like on the login page.
+func parseUniEmail(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "@") {
+		s += config.Config.UniEmailDomain
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s ||
+		!strings.HasSuffix(strings.ToLower(s), strings.ToLower(config.Config.UniEmailDomain)) {
+		return "", errNotUniEmail
+	}
+	return s, nil
+}
+
+// followerHash hashes an email address so that a ticket can't be followed
+// twice by the same address, without storing it in the clear.
+func followerHash(email string) string {
+	h := sha256.New()
+	h.Write([]byte(strings.ToLower(email) + config.Config.VoterPepper))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// followerCipher returns the cipher used to encrypt the email addresses of
+// followers.
+func followerCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(config.Config.FollowerKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptEmail(email string) (string, error) {
+	gcm, err := followerCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(email), nil)), nil
+}
+
+func decryptEmail(s string) (string, error) {
+	gcm, err := followerCipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("Encrypted email is too short")
+	}
+	email, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	return string(email), err
+}
+
+// newToken generates a random secret for private links.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// siteURL returns the absolute URL of a path, for links sent by email.
+func siteURL(path string) string {
+	return strings.TrimSuffix(config.Config.SiteURL, "/") + path
+}
+
+// notifyFollowers emails the followers of a ticket about an update in the
+// background. In development mode the emails are only logged.
+func notifyFollowers(ticket *models.Ticket, update string) {
+	followers := models.GetConfirmedFollowers(ticket.TicketID)
+	if len(followers) == 0 {
+		return
+	}
+
+	ticketURL := siteURL(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+	go func() {
+		for _, f := range followers {
+			unsubscribeURL := siteURL("/follow/unsubscribe/" + f.Token)
+			if config.Config.DevMode {
+				log.Printf("Not emailing a follower of ticket %d in development mode, unsubscribe: %s\n",
+					ticket.TicketID, unsubscribeURL)
+				continue
+			}
+			email, err := decryptEmail(f.EncryptedEmail)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if err = mailer.EmailTicketUpdate(email, ticket.Title, update, ticketURL, unsubscribeURL); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
+
+// PostTicketFollowHandler response for following a ticket by email.
+func PostTicketFollowHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+	ticketURL := fmt.Sprintf("/tickets/%d", ticket.TicketID)
+	if ticket.IsArchived() {
+		f.Error(errTicketArchived.Error())
+		ctx.Redirect(ticketURL)
+		return
+	}
+
+	email, err := parseUniEmail(ctx.Query("email"))
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect(ticketURL)
+		return
+	}
+	if err = checkRateLimit(ctx, sess, models.ActionFollow); err != nil {
+		f.Error(err.Error())
+		ctx.Redirect(ticketURL)
+		return
+	}
+
+	follower := &models.Follower{
+		TicketID:  ticket.TicketID,
+		EmailHash: followerHash(email),
+	}
+	if follower.EncryptedEmail, err = encryptEmail(email); err == nil {
+		if follower.Token, err = newToken(); err == nil {
+			follower, err = models.AddFollower(follower)
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		f.Error("Failed to follow the ticket")
+		ctx.Redirect(ticketURL)
+		return
+	}
+	recordRateLimit(ctx, sess, models.ActionFollow)
+
+	// Confirmed followers, and followers who were just emailed, aren't told
+	// apart, so the form doesn't reveal who follows the ticket.
+	send := false
+	if !follower.IsConfirmed {
+		if send, err = models.MarkFollowerEmailed(follower, followEmailCooldown); err != nil {
+			log.Println(err)
+		}
+	}
+	if send {
+		confirmURL := siteURL("/follow/confirm/" + follower.Token)
+		unsubscribeURL := siteURL("/follow/unsubscribe/" + follower.Token)
+		if config.Config.DevMode {
+			log.Println("Not emailing the confirmation in development mode:", confirmURL)
+		} else {
+			go func() {
+				err := mailer.EmailFollowConfirmation(email, ticket.Title, confirmURL, unsubscribeURL)
+				if err != nil {
+					log.Println(err)
+				}
+			}()
+		}
+	}
+
+	f.Success("Check your email to confirm following the ticket.")
+	ctx.Redirect(ticketURL)
+}
+
+// FollowConfirmHandler response for the confirmation link sent to a new
+// follower.
+func FollowConfirmHandler(ctx *emmanuel.Context, f *session.Flash) {
+	follower, err := models.GetFollowerByToken(ctx.Params("token"))
+	if err != nil {
+		f.Error("This link is no longer valid.")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if err = models.ConfirmFollower(follower); err != nil {
+		log.Println(err)
+		f.Error("Failed to confirm following the ticket")
+	} else {
+		f.Success("You will now receive emails about updates to this ticket.")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", follower.TicketID))
+}
+
+// FollowUnsubscribeHandler response for the unsubscribe link sent in every
+// email to a follower.
+func FollowUnsubscribeHandler(ctx *emmanuel.Context, f *session.Flash) {
+	follower, err := models.GetFollowerByToken(ctx.Params("token"))
+	if err != nil {
+		f.Info("You are not following this ticket.")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if err = models.DeleteFollower(follower.FollowerID); err != nil {
+		log.Println(err)
+		f.Error("Failed to unsubscribe")
+	} else {
+		f.Success("You will no longer receive emails about this ticket.")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", follower.TicketID))
+}
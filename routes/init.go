@@ -5,8 +5,8 @@ import (
 	"github.com/go-emmanuel/session"
 
 	"github.com/hw-cs-reps/platform/config"
-	"github.com/hw-cs-reps/platform/namegen"
 
+	"log"
 	"time"
 )
 
@@ -40,8 +40,17 @@ func ContextInit() emmanuel.Handler {
 		if config.Config.DevMode {
 			ctx.Data["DevMode"] = 1
 		}
-		if sess.Get("id") == nil {
-			sess.Set("id", namegen.GetName())
+		// The session ID is only used to rate limit the session, students
+		// post under a pseudonym of their own in each ticket. Older sessions
+		// were given a generated name, which isn't unique, and get a random
+		// ID instead.
+		if id, _ := sess.Get("id").(string); len(id) != sessionIDLength {
+			if id, err := newToken(); err != nil {
+				log.Println(err)
+			} else {
+				sess.Set("id", id)
+				sess.Set("since", time.Now().Unix())
+			}
 		}
 		ctx.Data["SiteTitle"] = config.Config.SiteName
 		ctx.Data["SiteScope"] = config.Config.SiteScope
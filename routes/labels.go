@@ -0,0 +1,229 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-emmanuel/csrf"
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+var (
+	// labelNamePattern limits label names to what reads well in a URL.
+	labelNamePattern   = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _-]{0,29}$`)
+	labelColourPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	errLabelName   = errors.New("Label names must be up to 30 letters, numbers, spaces, dashes or underscores!")
+	errLabelColour = errors.New("Label colours must be like #1e90ff!")
+	errLabelExists = errors.New("A label with that name already exists!")
+	errNoLabel     = errors.New("Label not found!")
+)
+
+// labelCheckbox is a label, and whether a ticket has it.
+type labelCheckbox struct {
+	models.Label
+	Checked bool
+}
+
+// validateLabel checks the name and colour of a label, and that no other label
+// has the name.
+func validateLabel(l *models.Label) error {
+	if !labelNamePattern.MatchString(l.Name) {
+		return errLabelName
+	}
+	if !labelColourPattern.MatchString(l.Colour) {
+		return errLabelColour
+	}
+	l.Colour = strings.ToLower(l.Colour)
+	if other, err := models.GetLabelByName(l.Name); err == nil && other.LabelID != l.LabelID {
+		return errLabelExists
+	}
+	return nil
+}
+
+// labelNames lists the names of labels for the moderation log.
+func labelNames(labels []models.Label) string {
+	if len(labels) == 0 {
+		return "none"
+	}
+	names := make([]string, len(labels))
+	for i, l := range labels {
+		names[i] = "\"" + l.Name + "\""
+	}
+	return strings.Join(names, ", ")
+}
+
+// LabelsHandler response for the page where reps manage labels.
+func LabelsHandler(ctx *emmanuel.Context, x csrf.CSRF) {
+	ctx.Data["Title"] = "Labels"
+	ctx.Data["IsTickets"] = 1
+	ctx.Data["Labels"] = models.GetLabels()
+	ctx.Data["csrf_token"] = x.GetToken()
+	ctx.HTML(200, "labels")
+}
+
+// PostNewLabelHandler response for creating a label.
+func PostNewLabelHandler(ctx *emmanuel.Context, f *session.Flash) {
+	l := models.Label{
+		Name:   ctx.QueryTrim("name"),
+		Colour: ctx.QueryTrim("colour"),
+	}
+	if err := validateLabel(&l); err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/labels")
+		return
+	}
+	if err := models.AddLabel(&l); err != nil {
+		log.Println(err)
+		f.Error("Failed to add label")
+		ctx.Redirect("/labels")
+		return
+	}
+
+	m := models.Moderation{
+		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
+		Title:       "Label \"" + l.Name + "\"",
+		Description: "Created with colour " + l.Colour,
+	}
+	models.AddModeration(&m)
+	f.Success("Label created!")
+	ctx.Redirect("/labels")
+}
+
+// PostLabelEditHandler response for renaming or recolouring a label.
+func PostLabelEditHandler(ctx *emmanuel.Context, f *session.Flash) {
+	l, err := models.GetLabel(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error(errNoLabel.Error())
+		ctx.Redirect("/labels")
+		return
+	}
+	old := *l
+	l.Name = ctx.QueryTrim("name")
+	l.Colour = ctx.QueryTrim("colour")
+	if err = validateLabel(l); err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/labels")
+		return
+	}
+	if l.Name == old.Name && l.Colour == old.Colour {
+		ctx.Redirect("/labels")
+		return
+	}
+	if err = models.UpdateLabel(l); err != nil {
+		log.Println(err)
+		f.Error("Failed to update label")
+		ctx.Redirect("/labels")
+		return
+	}
+
+	var changes []string
+	if l.Name != old.Name {
+		changes = append(changes, "renamed to \""+l.Name+"\"")
+	}
+	if l.Colour != old.Colour {
+		changes = append(changes, "changed colour from "+old.Colour+" to "+l.Colour)
+	}
+	m := models.Moderation{
+		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
+		Title:       "Label \"" + old.Name + "\"",
+		Description: strings.Join(changes, " and also "),
+	}
+	models.AddModeration(&m)
+	f.Success("Label updated!")
+	ctx.Redirect("/labels")
+}
+
+// PostLabelDeleteHandler response for deleting a label.
+func PostLabelDeleteHandler(ctx *emmanuel.Context, f *session.Flash) {
+	l, err := models.GetLabel(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error(errNoLabel.Error())
+		ctx.Redirect("/labels")
+		return
+	}
+	if err = models.DeleteLabel(l.LabelID); err != nil {
+		log.Println(err)
+		f.Error("Failed to delete label")
+		ctx.Redirect("/labels")
+		return
+	}
+
+	m := models.Moderation{
+		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
+		Title:       "Label \"" + l.Name + "\"",
+		Description: "Deleted, removing it from every ticket",
+	}
+	models.AddModeration(&m)
+	f.Success("Label deleted!")
+	ctx.Redirect("/labels")
+}
+
+// getLabelCheckboxes returns every label, marking those the ticket has.
+func getLabelCheckboxes(labels []models.Label) (boxes []labelCheckbox) {
+	has := make(map[int64]bool, len(labels))
+	for _, l := range labels {
+		has[l.LabelID] = true
+	}
+	for _, l := range models.GetLabels() {
+		boxes = append(boxes, labelCheckbox{l, has[l.LabelID]})
+	}
+	return
+}
+
+// PostTicketLabelsHandler response for changing the labels of a ticket.
+func PostTicketLabelsHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	ticketURL := fmt.Sprintf("/tickets/%d", ticket.TicketID)
+	if ticket.IsArchived() {
+		f.Error(errTicketArchived.Error())
+		ctx.Redirect(ticketURL)
+		return
+	}
+
+	var ids []int64
+	for _, id := range ctx.QueryStrings("label") {
+		labelID, err := strconv.ParseInt(id, 10, 64)
+		if err == nil {
+			_, err = models.GetLabel(labelID)
+		}
+		if err != nil {
+			f.Error(errNoLabel.Error())
+			ctx.Redirect(ticketURL)
+			return
+		}
+		ids = append(ids, labelID)
+	}
+
+	from := models.GetTicketLabels(ticket.TicketID)
+	if err = models.SetTicketLabels(ticket.TicketID, ids); err != nil {
+		log.Println(err)
+		f.Error("Failed to change labels")
+		ctx.Redirect(ticketURL)
+		return
+	}
+	to := models.GetTicketLabels(ticket.TicketID)
+	if labelNames(from) != labelNames(to) {
+		m := models.Moderation{
+			Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
+			Title:       "Ticket \"" + ticket.Title + "\"",
+			Description: "Changed labels from " + labelNames(from) + " to " + labelNames(to),
+		}
+		models.AddModeration(&m)
+	}
+	f.Success("Labels updated!")
+	ctx.Redirect(ticketURL)
+}
@@ -78,6 +78,7 @@ func PostConfigHandler(ctx *emmanuel.Context, sess session.Store, f *session.Fla
 
 	f.Success("Configuration updated correctly!")
 
+	conf.ContentFilter.Compile()
 	config.Config.InstanceConfig = conf
 	config.SaveConfig()
 	ctx.Redirect("/config")
@@ -0,0 +1,397 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+// maxPollOptions is the most options a poll can have.
+const maxPollOptions = 10
+
+var (
+	errPollQuestion  = errors.New("Please ask a question of up to 200 characters!")
+	errPollType      = errors.New("Invalid poll type!")
+	errPollOptions   = errors.New(fmt.Sprintf("Polls need between 2 and %d different options!", maxPollOptions))
+	errPollCloses    = errors.New("The closing time must be in the future!")
+	errPollClosed    = errors.New("This poll is closed!")
+	errPollVoted     = errors.New("You have already voted in this poll!")
+	errPollChoice    = errors.New("Please choose an option!")
+	errPollRanks     = errors.New("Please give each option you rank a different rank!")
+	errPollNotOnItem = errors.New("Voting is closed on this ticket!")
+)
+
+// pollResult is how an option of a poll fared. Score is the number of votes,
+// or the points of the option in ranked polls.
+type pollResult struct {
+	Option  string
+	Score   int
+	Percent int
+}
+
+// pollView is a poll shown under a ticket or announcement.
+type pollView struct {
+	models.Poll
+	Ballots     int
+	Results     []pollResult
+	HasVoted    bool
+	CanVote     bool
+	ShowResults bool
+	Ranks       []int // Ranks are the ranks which can be given to options in ranked polls.
+}
+
+// pollResults counts the ballots of a poll. In ranked polls, an option gets a
+// point for each option ranked below it on a ballot, and one more for being
+// ranked at all.
+func pollResults(p *models.Poll, ballots []models.PollBallot) []pollResult {
+	results := make([]pollResult, len(p.Options))
+	total := 0
+	for i, o := range p.Options {
+		results[i].Option = o
+	}
+	for _, b := range ballots {
+		for pos, c := range b.Choices {
+			if c < 0 || c >= len(results) {
+				continue
+			}
+			points := 1
+			if p.Type == models.PollRanked {
+				points = len(p.Options) - pos
+			}
+			results[c].Score += points
+			total += points
+		}
+	}
+	for i := range results {
+		if p.Type != models.PollRanked && len(ballots) > 0 {
+			results[i].Percent = results[i].Score * 100 / len(ballots)
+		} else if total > 0 {
+			results[i].Percent = results[i].Score * 100 / total
+		}
+	}
+	return results
+}
+
+// getPollViews prepares the polls of a ticket or announcement for showing to a
+// voter. Results hidden until a poll closes are still shown to admins.
+func getPollViews(polls []models.Poll, voterHash string, isAdmin, canVote bool) (views []pollView) {
+	for i := range polls {
+		p := &polls[i]
+		ballots := models.GetBallots(p.PollID)
+		v := pollView{
+			Poll:        *p,
+			Ballots:     len(ballots),
+			Results:     pollResults(p, ballots),
+			HasVoted:    models.HasVotedInPoll(p.PollID, voterHash),
+			ShowResults: !p.HideResults || p.IsClosed() || isAdmin,
+		}
+		v.CanVote = canVote && !v.HasVoted && !p.IsClosed()
+		if p.Type == models.PollRanked {
+			for r := 1; r <= len(p.Options); r++ {
+				v.Ranks = append(v.Ranks, r)
+			}
+		}
+		views = append(views, v)
+	}
+	return
+}
+
+// parsePoll reads a new poll from a form.
+func parsePoll(ctx *emmanuel.Context) (*models.Poll, error) {
+	p := &models.Poll{
+		Question:    ctx.QueryTrim("question"),
+		Type:        models.PollType(ctx.Query("type")),
+		HideResults: ctx.Query("hide_results") == "on",
+	}
+	if p.Question == "" || len(p.Question) > 200 {
+		return nil, errPollQuestion
+	}
+	if !p.Type.IsValid() {
+		return nil, errPollType
+	}
+
+	seen := make(map[string]bool)
+	for _, o := range strings.Split(ctx.Query("options"), "\n") {
+		o = strings.TrimSpace(o)
+		if o != "" && !seen[o] {
+			seen[o] = true
+			p.Options = append(p.Options, o)
+		}
+	}
+	if len(p.Options) < 2 || len(p.Options) > maxPollOptions {
+		return nil, errPollOptions
+	}
+
+	if closes := ctx.QueryTrim("closes"); closes != "" {
+		t, err := time.ParseInLocation("2006-01-02T15:04", closes, time.Local)
+		if err != nil || t.Before(time.Now()) {
+			return nil, errPollCloses
+		}
+		p.ClosesUnix = t.Unix()
+	}
+	return p, nil
+}
+
+// parseChoices reads the options chosen in a poll from a form, most preferred
+// first in ranked polls.
+func parseChoices(ctx *emmanuel.Context, p *models.Poll) ([]int, error) {
+	if p.Type == models.PollRanked {
+		ranks := make(map[int]int) // rank to option
+		for i := range p.Options {
+			v := ctx.Query("rank-" + strconv.Itoa(i))
+			if v == "" {
+				continue
+			}
+			r, err := strconv.Atoi(v)
+			if err != nil || r < 1 || r > len(p.Options) {
+				return nil, errPollRanks
+			}
+			if _, ok := ranks[r]; ok {
+				return nil, errPollRanks
+			}
+			ranks[r] = i
+		}
+		if len(ranks) == 0 {
+			return nil, errPollChoice
+		}
+		var order []int
+		for r := range ranks {
+			order = append(order, r)
+		}
+		sort.Ints(order)
+		choices := make([]int, len(order))
+		for i, r := range order {
+			choices[i] = ranks[r]
+		}
+		return choices, nil
+	}
+
+	var choices []int
+	seen := make(map[int]bool)
+	for _, v := range ctx.QueryStrings("choice") {
+		c, err := strconv.Atoi(v)
+		if err != nil || c < 0 || c >= len(p.Options) {
+			return nil, errPollChoice
+		}
+		if !seen[c] {
+			seen[c] = true
+			choices = append(choices, c)
+		}
+	}
+	if len(choices) == 0 || (p.Type == models.PollSingle && len(choices) > 1) {
+		return nil, errPollChoice
+	}
+	return choices, nil
+}
+
+// pollItem returns the page of the ticket or announcement a poll is on, and
+// whether the poll can be voted in there.
+func pollItem(p *models.Poll, sess session.Store) (url string, canVote bool, err error) {
+	if p.TicketID == 0 {
+		return fmt.Sprintf("/a/%d", p.AnnouncementID), true, nil
+	}
+	ticket, err := models.GetTicket(p.TicketID)
+	if err != nil || isHiddenTicket(ticket, sess) {
+		return "", false, errors.New("Ticket not found!")
+	}
+	return fmt.Sprintf("/tickets/%d", ticket.TicketID), canVotePolls(ticket), nil
+}
+
+// canVotePolls checks whether the polls of a ticket can be voted in.
+func canVotePolls(ticket *models.Ticket) bool {
+	return !ticket.IsArchived() && !ticket.IsLocked && !ticket.IsPending
+}
+
+// addPoll adds a poll and logs it as done by the admin.
+func addPoll(p *models.Poll, itemTitle, admin string) error {
+	p.Admin = admin
+	if err := models.AddPoll(p); err != nil {
+		return err
+	}
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       itemTitle,
+		Description: "Added the " + strings.ToLower(p.Type.Name()) + " poll \"" + p.Question + "\"",
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostTicketPollHandler response for adding a poll to a ticket.
+func PostTicketPollHandler(ctx *emmanuel.Context, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	ticketURL := fmt.Sprintf("/tickets/%d", ticket.TicketID)
+	p, err := parsePoll(ctx)
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect(ticketURL)
+		return
+	}
+
+	p.TicketID = ticket.TicketID
+	if err = addPoll(p, "Ticket \""+ticket.Title+"\"", ctx.Data["User"].(config.ClassRepresentative).Name); err != nil {
+		log.Println(err)
+		f.Error("Failed to add the poll")
+	} else {
+		f.Success("Poll added!")
+	}
+	ctx.Redirect(ticketURL)
+}
+
+// PostAnnouncementPollHandler response for adding a poll to an announcement.
+func PostAnnouncementPollHandler(ctx *emmanuel.Context, f *session.Flash) {
+	announcement, err := models.GetAnnouncement(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Announcement not found!")
+		ctx.Redirect("/a")
+		return
+	}
+	announcementURL := fmt.Sprintf("/a/%d", announcement.AnnouncementID)
+	p, err := parsePoll(ctx)
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect(announcementURL)
+		return
+	}
+
+	p.AnnouncementID = announcement.AnnouncementID
+	err = addPoll(p, "Announcement \""+announcement.Title+"\"", ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		log.Println(err)
+		f.Error("Failed to add the poll")
+	} else {
+		f.Success("Poll added!")
+	}
+	ctx.Redirect(announcementURL)
+}
+
+// PostPollVoteHandler response for voting in a poll.
+func PostPollVoteHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	p, err := models.GetPoll(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Poll not found!")
+		ctx.Redirect("/")
+		return
+	}
+	url, canVote, err := pollItem(p, sess)
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/")
+		return
+	}
+	url += fmt.Sprintf("#poll-%d", p.PollID)
+
+	choices, err := parseChoices(ctx, p)
+	if err == nil && !canVote {
+		err = errPollNotOnItem
+	} else if err == nil && p.IsClosed() {
+		err = errPollClosed
+	}
+	if err == nil {
+		var added bool
+		added, err = models.AddBallot(&models.PollBallot{
+			PollID:    p.PollID,
+			VoterHash: userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")),
+			Choices:   choices,
+		})
+		if err != nil {
+			log.Println(err)
+			err = errors.New("Failed to record your vote")
+		} else if !added {
+			err = errPollVoted
+		}
+	}
+	if err != nil {
+		f.Error(err.Error())
+	} else {
+		f.Success("Vote recorded!")
+	}
+	ctx.Redirect(url)
+}
+
+// pollLogTitle returns the title of a poll in the moderation log.
+func pollLogTitle(p *models.Poll) string {
+	return "Poll \"" + p.Question + "\""
+}
+
+// PostPollCloseHandler response for ending voting in a poll.
+func PostPollCloseHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	p, err := models.GetPoll(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Poll not found!")
+		ctx.Redirect("/")
+		return
+	}
+	url, _, err := pollItem(p, sess)
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/")
+		return
+	}
+	if p.IsClosed() {
+		f.Error(errPollClosed.Error())
+		ctx.Redirect(url)
+		return
+	}
+	if err = models.ClosePoll(p); err != nil {
+		log.Println(err)
+		f.Error("Failed to close the poll")
+		ctx.Redirect(url)
+		return
+	}
+
+	m := models.Moderation{
+		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
+		Title:       pollLogTitle(p),
+		Description: "Closed, ending voting",
+	}
+	models.AddModeration(&m)
+	f.Success("Poll closed!")
+	ctx.Redirect(url)
+}
+
+// PostPollDeleteHandler response for deleting a poll.
+func PostPollDeleteHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	p, err := models.GetPoll(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Poll not found!")
+		ctx.Redirect("/")
+		return
+	}
+	url, _, err := pollItem(p, sess)
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/")
+		return
+	}
+	if err = models.DeletePoll(p.PollID); err != nil {
+		log.Println(err)
+		f.Error("Failed to delete the poll")
+		ctx.Redirect(url)
+		return
+	}
+
+	m := models.Moderation{
+		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
+		Title:       pollLogTitle(p),
+		Description: "Deleted, along with its votes",
+	}
+	models.AddModeration(&m)
+	f.Success("Poll deleted!")
+	ctx.Redirect(url)
+}
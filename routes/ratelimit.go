@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+	"github.com/hako/durafmt"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+// rateLimitedError is returned when an anonymous user does an action too often.
+type rateLimitedError struct {
+	noun  string
+	retry time.Time
+}
+
+func (e rateLimitedError) Error() string {
+	wait := time.Until(e.retry)
+	if wait < time.Minute {
+		wait = time.Minute
+	}
+	return fmt.Sprintf("You are %s too quickly! Please try again in %s.", e.noun,
+		durafmt.Parse(wait.Round(time.Minute)).LimitFirstN(1).String())
+}
+
+// rateLimit returns the limit of an action, and how the action is described in
+// errors.
+func rateLimit(action string) (limit config.RateLimit, noun string) {
+	switch action {
+	case models.ActionTicket:
+		limit, noun = config.Config.RateLimits.Tickets, "posting tickets"
+	case models.ActionComment:
+		limit, noun = config.Config.RateLimits.Comments, "commenting"
+	case models.ActionVote:
+		limit, noun = config.Config.RateLimits.Votes, "upvoting"
+	case models.ActionFollow:
+		limit, noun = config.Config.RateLimits.Follows, "following tickets"
+	case models.ActionReport:
+		limit, noun = config.Config.RateLimits.Reports, "reporting"
+	}
+	return
+}
+
+// isRateLimited checks whether an action is limited for the user. Class
+// representatives are exempt.
+func isRateLimited(sess session.Store, limit config.RateLimit) bool {
+	return sess.Get("isadmin") != 1 && limit.Count > 0 && limit.Period > 0
+}
+
+// sessionIDLength is the length of the random ID of sessions.
+const sessionIDLength = 64
+
+// sessionID returns the random ID of the session, which rate limits it.
+func sessionID(sess session.Store) string {
+	id, _ := sess.Get("id").(string)
+	return id
+}
+
+// sessionStart returns when the session was given its ID.
+func sessionStart(sess session.Store) int64 {
+	since, _ := sess.Get("since").(int64)
+	return since
+}
+
+// checkRateLimit returns an error if an anonymous user has done an action too
+// often. The action only counts once it is recorded with recordRateLimit.
+func checkRateLimit(ctx *emmanuel.Context, sess session.Store, action string) error {
+	limit, noun := rateLimit(action)
+	if !isRateLimited(sess, limit) {
+		return nil
+	}
+
+	retry, err := models.CheckRateLimit(action, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")),
+		sessionID(sess), limit.Count, limit.Period)
+	if err != nil {
+		// Posting isn't blocked when the limit can't be checked.
+		log.Println(err)
+		return nil
+	}
+	if retry != 0 {
+		return rateLimitedError{noun, time.Unix(retry, 0)}
+	}
+	return nil
+}
+
+// recordRateLimit records an anonymous user doing an action, after it was
+// done, so failed attempts don't count towards the limit.
+func recordRateLimit(ctx *emmanuel.Context, sess session.Store, action string) {
+	limit, _ := rateLimit(action)
+	if !isRateLimited(sess, limit) {
+		return
+	}
+	err := models.AddRateLimitHit(action, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")),
+		sessionID(sess), limit.Period)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,257 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-emmanuel/csrf"
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+const (
+	// maxReportReason is the longest reason a report can give.
+	maxReportReason = 500
+	// reportSessionAge is how long in seconds a session must have started
+	// before reporting for the report to count towards hiding the item, so
+	// sessions made up just to report an item don't hide it.
+	reportSessionAge = 15 * 60
+)
+
+var (
+	errNoReportReason  = errors.New("Please give a reason for your report!")
+	errLongReport      = errors.New("The reason for your report is too long!")
+	errAlreadyReported = errors.New("You have already reported this!")
+)
+
+// studentsCount formats a number of students.
+func studentsCount(n int64) string {
+	if n == 1 {
+		return "1 student"
+	}
+	return fmt.Sprintf("%d students", n)
+}
+
+// reportItem records a student's report of a ticket or comment. Once enough
+// students have reported it from independent sessions, the item is hidden
+// until a class representative reviews it.
+func reportItem(ctx *emmanuel.Context, sess session.Store, kind models.ReportKind, itemID, ticketID int64,
+	reason string) error {
+	if reason == "" {
+		return errNoReportReason
+	}
+	if len(reason) > maxReportReason {
+		return errLongReport
+	}
+	if err := checkRateLimit(ctx, sess, models.ActionReport); err != nil {
+		return err
+	}
+	added, err := models.AddReport(&models.Report{
+		Kind:        kind,
+		ItemID:      itemID,
+		TicketID:    ticketID,
+		VoterHash:   userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")),
+		SessionID:   sessionID(sess),
+		SessionUnix: sessionStart(sess),
+		Reason:      reason,
+	})
+	if err != nil {
+		return err
+	} else if !added {
+		return errAlreadyReported
+	}
+	recordRateLimit(ctx, sess, models.ActionReport)
+
+	threshold := config.Config.InstanceConfig.ReportThreshold
+	count := models.CountIndependentReports(kind, itemID, reportSessionAge)
+	if threshold <= 0 || count < int64(threshold) {
+		return nil
+	}
+	held := "Reported by " + studentsCount(count)
+	m := models.Moderation{
+		Admin:       models.SystemActor,
+		Description: held + ", so hidden until a class representative reviews it",
+	}
+	switch kind {
+	case models.ReportTicket:
+		ticket, err := models.GetTicket(itemID)
+		if err != nil || ticket.IsPending {
+			return err
+		}
+		m.Title = "Ticket \"" + ticket.Title + "\""
+		err = models.HoldTicket(ticket, held)
+		if err != nil {
+			return err
+		}
+	case models.ReportComment:
+		c, err := models.GetComment(itemID)
+		if err != nil || c.IsPending {
+			return err
+		}
+		m.Title = commentLogTitle(c)
+		if err = models.HoldComment(c, held); err != nil {
+			return err
+		}
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostTicketReportHandler response for reporting a ticket.
+func PostTicketReportHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+
+	err = reportItem(ctx, sess, models.ReportTicket, ticket.TicketID, ticket.TicketID, ctx.QueryTrim("reason"))
+	if err != nil {
+		f.Error(err.Error())
+	} else {
+		f.Success("Thank you for your report. The class representatives will review it.")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
+
+// PostCommentReportHandler response for reporting a ticket's comment.
+func PostCommentReportHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	ticketURL := fmt.Sprintf("/tickets/%d", ticket.TicketID)
+	c, err := models.GetComment(ctx.ParamsInt64("cid"))
+	if err != nil || c.TicketID != ticket.TicketID || c.IsDeleted || c.IsPending {
+		f.Error("Comment not found!")
+		ctx.Redirect(ticketURL)
+		return
+	}
+
+	err = reportItem(ctx, sess, models.ReportComment, c.CommentID, ticket.TicketID, ctx.QueryTrim("reason"))
+	if err != nil {
+		f.Error(err.Error())
+	} else {
+		f.Success("Thank you for your report. The class representatives will review it.")
+	}
+	ctx.Redirect(ticketURL)
+}
+
+// reportedItem is a reported ticket or comment shown on the triage dashboard.
+type reportedItem struct {
+	models.ReportedItem
+	Ticket  *models.Ticket
+	Comment *models.Comment
+	Reports []models.Report
+}
+
+// IsHidden checks whether the item is hidden while waiting for moderation.
+func (r reportedItem) IsHidden() bool {
+	if r.Comment != nil {
+		return r.Comment.IsPending
+	}
+	return r.Ticket.IsPending
+}
+
+// ReportsHandler response for the triage dashboard of reported tickets and
+// comments.
+func ReportsHandler(ctx *emmanuel.Context, x csrf.CSRF) {
+	var items []reportedItem
+	for _, r := range models.GetReportedItems() {
+		item := reportedItem{ReportedItem: r, Reports: models.GetReports(r.Kind, r.ItemID)}
+		var err error
+		if item.Ticket, err = models.GetTicket(r.TicketID); err != nil {
+			continue
+		}
+		if r.Kind == models.ReportComment {
+			if item.Comment, err = models.GetComment(r.ItemID); err != nil || item.Comment.IsDeleted {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+
+	ctx.Data["Title"] = "Reports"
+	ctx.Data["IsTickets"] = 1
+	ctx.Data["Items"] = items
+	ctx.Data["ReportThreshold"] = config.Config.InstanceConfig.ReportThreshold
+	ctx.Data["csrf_token"] = x.GetToken()
+	ctx.HTML(200, "reports")
+}
+
+// PostReportReviewHandler response for keeping or removing a reported ticket or
+// comment.
+func PostReportReviewHandler(ctx *emmanuel.Context, f *session.Flash) {
+	kind := models.ReportKind(ctx.Params("kind"))
+	itemID := ctx.ParamsInt64("id")
+	count := models.CountReports(kind, itemID)
+	if count == 0 {
+		f.Error("No reports to review!")
+		ctx.Redirect("/reports")
+		return
+	}
+	remove := ctx.Query("action") == "remove"
+
+	m := models.Moderation{
+		Admin:  ctx.Data["User"].(config.ClassRepresentative).Name,
+		Reason: ctx.QueryTrim("reason"),
+	}
+	var err error
+	switch kind {
+	case models.ReportTicket:
+		var ticket *models.Ticket
+		if ticket, err = models.GetTicket(itemID); err != nil {
+			break
+		}
+		m.Title = "Ticket \"" + ticket.Title + "\""
+		if remove {
+			if err = models.DelTicket(ticket.TicketID); err == nil {
+				removeUnusedAttachments()
+			}
+		} else if ticket.IsPending {
+			err = models.ApproveTicket(ticket)
+		}
+	case models.ReportComment:
+		var c *models.Comment
+		if c, err = models.GetComment(itemID); err != nil {
+			break
+		}
+		m.Title = commentLogTitle(c)
+		if remove {
+			err = models.DeleteComment(c.CommentID)
+		} else if c.IsPending {
+			err = models.ApproveComment(c)
+		}
+	default:
+		err = fmt.Errorf("Unknown report kind %q", kind)
+	}
+	if err == nil {
+		err = models.ResolveReports(kind, itemID)
+	}
+	if err != nil {
+		log.Println(err)
+		f.Error("Failed to review the reports")
+		ctx.Redirect("/reports")
+		return
+	}
+
+	if remove {
+		m.Description = "Removed after being reported by " + studentsCount(count)
+		f.Success("Reported " + string(kind) + " removed!")
+	} else {
+		m.Description = "Kept after being reported by " + studentsCount(count)
+		f.Success("Reports dismissed!")
+	}
+	models.AddModeration(&m)
+	ctx.Redirect("/reports")
+}
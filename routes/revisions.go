@@ -0,0 +1,292 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-emmanuel/csrf"
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/config"
+	"github.com/hw-cs-reps/platform/models"
+)
+
+// diffRow is a row of a side-by-side diff. A class is "alert-red" for a removed
+// line, "alert-green" for an added line, and empty otherwise.
+type diffRow struct {
+	Left, Right           string
+	LeftClass, RightClass string
+}
+
+// diffLines compares two texts line by line, and pairs up the lines for showing
+// them side by side.
+func diffLines(a, b string) (rows []diffRow) {
+	left, right := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if a == "" {
+		left = nil
+	}
+	if b == "" {
+		right = nil
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of left[i:]
+	// and right[j:].
+	lcs := make([][]int, len(left)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(right)+1)
+	}
+	for i := len(left) - 1; i >= 0; i-- {
+		for j := len(right) - 1; j >= 0; j-- {
+			if left[i] == right[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Removed and added lines between unchanged lines are shown next to each
+	// other.
+	var removed, added []string
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			var row diffRow
+			if k < len(removed) {
+				row.Left, row.LeftClass = removed[k], "alert-red"
+			}
+			if k < len(added) {
+				row.Right, row.RightClass = added[k], "alert-green"
+			}
+			rows = append(rows, row)
+		}
+		removed, added = nil, nil
+	}
+	i, j := 0, 0
+	for i < len(left) || j < len(right) {
+		switch {
+		case i < len(left) && j < len(right) && left[i] == right[j]:
+			flush()
+			rows = append(rows, diffRow{Left: left[i], Right: right[j]})
+			i++
+			j++
+		case j >= len(right) || (i < len(left) && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, left[i])
+			i++
+		default:
+			added = append(added, right[j])
+			j++
+		}
+	}
+	flush()
+	return
+}
+
+// fieldDiff is the side-by-side diff of a field which changed in a revision.
+type fieldDiff struct {
+	Name string
+	Rows []diffRow
+}
+
+// revisionView is a revision shown on a history page, with what changed since
+// the revision before it.
+type revisionView struct {
+	models.Revision
+	Number    int
+	Changes   []fieldDiff
+	IsCurrent bool
+	IsHidden  bool // IsHidden is whether the revision is hidden from the public.
+}
+
+// getRevisionViews returns the revisions of a ticket or announcement, newest
+// first. Only admins can see the revisions before one marked as sensitive.
+func getRevisionViews(kind models.RevisionKind, itemID int64, isAdmin bool) (views []revisionView) {
+	revisions := models.GetRevisions(kind, itemID)
+	lastSensitive := -1
+	for i, r := range revisions {
+		if r.IsSensitive {
+			lastSensitive = i
+		}
+	}
+
+	var prev models.Revision
+	for i, r := range revisions {
+		v := revisionView{
+			Revision:  r,
+			Number:    i + 1,
+			IsCurrent: i == len(revisions)-1,
+			IsHidden:  !isAdmin && i < lastSensitive,
+		}
+		// The changes of a sensitive revision would show what it removed.
+		if !v.IsHidden && (isAdmin || i != lastSensitive) {
+			fields := []struct{ name, before, after string }{
+				{"Title", prev.Title, r.Title},
+				{"Category", prev.Category, r.Category},
+				{"Tags", prev.Tags, r.Tags},
+				{"Description", prev.Description, r.Description},
+			}
+			for _, f := range fields {
+				if f.before != f.after {
+					v.Changes = append(v.Changes, fieldDiff{f.name, diffLines(f.before, f.after)})
+				}
+			}
+		}
+		if v.IsHidden {
+			v.Revision = models.Revision{RevisionID: r.RevisionID, Admin: r.Admin, CreatedUnix: r.CreatedUnix}
+		}
+		views = append(views, v)
+		prev = r
+	}
+
+	for i, j := 0, len(views)-1; i < j; i, j = i+1, j-1 {
+		views[i], views[j] = views[j], views[i]
+	}
+	return
+}
+
+// TicketHistoryHandler response for the revisions of a ticket.
+func TicketHistoryHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash, x csrf.CSRF) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil || isHiddenTicket(ticket, sess) {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+
+	ctx.Data["Title"] = ticket.Title + " - History"
+	ctx.Data["IsTickets"] = 1
+	ctx.Data["ItemTitle"] = ticket.Title
+	ctx.Data["ItemURL"] = fmt.Sprintf("/tickets/%d", ticket.TicketID)
+	ctx.Data["Revisions"] = getRevisionViews(models.RevisionTicket, ticket.TicketID, ctx.Data["IsAdmin"] == 1)
+	ctx.Data["IsReadOnly"] = ticket.IsArchived()
+	ctx.Data["csrf_token"] = x.GetToken()
+	ctx.HTML(200, "history")
+}
+
+// AnnouncementHistoryHandler response for the revisions of an announcement.
+func AnnouncementHistoryHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash, x csrf.CSRF) {
+	announcement, err := models.GetAnnouncement(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Announcement not found!")
+		ctx.Redirect("/a")
+		return
+	}
+
+	ctx.Data["Title"] = announcement.Title + " - History"
+	ctx.Data["IsAnnouncements"] = 1
+	ctx.Data["ItemTitle"] = announcement.Title
+	ctx.Data["ItemURL"] = fmt.Sprintf("/a/%d", announcement.AnnouncementID)
+	ctx.Data["Revisions"] = getRevisionViews(models.RevisionAnnouncement, announcement.AnnouncementID,
+		ctx.Data["IsAdmin"] == 1)
+	ctx.Data["csrf_token"] = x.GetToken()
+	ctx.HTML(200, "history")
+}
+
+// getItemRevision fetches a revision, checking it is of the item.
+func getItemRevision(id int64, kind models.RevisionKind, itemID int64) (*models.Revision, error) {
+	r, err := models.GetRevision(id)
+	if err == nil && (r.Kind != kind || r.ItemID != itemID) {
+		err = fmt.Errorf("Revision %d is not of %s %d", id, kind, itemID)
+	}
+	return r, err
+}
+
+// PostTicketRestoreHandler response for restoring a ticket to a revision.
+func PostTicketRestoreHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	historyURL := fmt.Sprintf("/tickets/%d/history", ticket.TicketID)
+	if ticket.IsArchived() {
+		f.Error(errTicketArchived.Error())
+		ctx.Redirect(historyURL)
+		return
+	}
+	r, err := getItemRevision(ctx.ParamsInt64("rid"), models.RevisionTicket, ticket.TicketID)
+	if err != nil {
+		f.Error("Revision not found!")
+		ctx.Redirect(historyURL)
+		return
+	}
+	if !hasCategory(r.Category) {
+		f.Error("The category of the revision no longer exists!")
+		ctx.Redirect(historyURL)
+		return
+	}
+
+	admin := ctx.Data["User"].(config.ClassRepresentative).Name
+	before := models.TicketRevision(ticket)
+	ticket.Title, ticket.Description, ticket.Category = r.Title, r.Description, r.Category
+	if err = models.UpdateTicketCols(ticket, "title", "description", "category"); err == nil {
+		restored := models.TicketRevision(ticket)
+		restored.Admin = admin
+		err = models.AddRevision(before, restored)
+	}
+	if err != nil {
+		log.Println(err)
+		f.Error("Failed to restore the revision")
+		ctx.Redirect(historyURL)
+		return
+	}
+
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Restored the revision from " + time.Unix(r.CreatedUnix, 0).Format("2006-01-02 15:04"),
+	}
+	models.AddModeration(&m)
+	f.Success("Revision restored!")
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
+
+// PostAnnouncementRestoreHandler response for restoring an announcement to a
+// revision.
+func PostAnnouncementRestoreHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	announcement, err := models.GetAnnouncement(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Announcement not found!")
+		ctx.Redirect("/a")
+		return
+	}
+	historyURL := fmt.Sprintf("/a/%d/history", announcement.AnnouncementID)
+	r, err := getItemRevision(ctx.ParamsInt64("rid"), models.RevisionAnnouncement, announcement.AnnouncementID)
+	if err != nil {
+		f.Error("Revision not found!")
+		ctx.Redirect(historyURL)
+		return
+	}
+
+	admin := ctx.Data["User"].(config.ClassRepresentative).Name
+	before := models.AnnouncementRevision(announcement)
+	announcement.Title, announcement.Description, announcement.Tags = r.Title, r.Description, r.Tags
+	if err = models.UpdateAnnouncementCols(announcement, "title", "description", "tags"); err == nil {
+		restored := models.AnnouncementRevision(announcement)
+		restored.Admin = admin
+		err = models.AddRevision(before, restored)
+	}
+	if err != nil {
+		log.Println(err)
+		f.Error("Failed to restore the revision")
+		ctx.Redirect(historyURL)
+		return
+	}
+
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Announcement \"" + announcement.Title + "\"",
+		Description: "Restored the revision from " + time.Unix(r.CreatedUnix, 0).Format("2006-01-02 15:04"),
+	}
+	models.AddModeration(&m)
+	f.Success("Revision restored!")
+	ctx.Redirect(fmt.Sprintf("/a/%d", announcement.AnnouncementID))
+}
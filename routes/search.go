@@ -0,0 +1,226 @@
+package routes
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/go-emmanuel/emmanuel"
+	"github.com/go-emmanuel/session"
+
+	"github.com/hw-cs-reps/platform/models"
+)
+
+const (
+	// searchLimit is the maximum number of results of a search.
+	searchLimit = 100
+	// snippetWords is the number of words shown around the first match.
+	snippetWords = 30
+	// similarLimit is the maximum number of similar tickets suggested.
+	similarLimit = 5
+)
+
+// searchHit is a search result prepared for display.
+type searchHit struct {
+	Kind     string        `json:"kind"`
+	ID       int64         `json:"id"`
+	TicketID int64         `json:"ticket_id,omitempty"`
+	URL      string        `json:"url"`
+	Title    template.HTML `json:"title"`
+	Snippet  template.HTML `json:"snippet"`
+	Score    float64       `json:"score"`
+}
+
+func newSearchHit(r models.SearchResult, terms []string) searchHit {
+	hit := searchHit{
+		Kind:     r.Kind.String(),
+		ID:       r.ID,
+		TicketID: r.TicketID,
+		Title:    highlight(r.Title, terms),
+		Score:    r.Score,
+	}
+
+	body := r.Body
+	switch r.Kind {
+	case models.SearchTicket:
+		hit.URL = fmt.Sprintf("/tickets/%d", r.ID)
+	case models.SearchComment:
+		hit.URL = fmt.Sprintf("/tickets/%d#c-%d", r.TicketID, r.ID)
+	case models.SearchAnnouncement:
+		hit.URL = fmt.Sprintf("/a/%d", r.ID)
+	}
+	if r.Kind != models.SearchComment { // only comments are plain text
+		body = html.UnescapeString(summaryPolicy.Sanitize(markdownToHTML(body)))
+	}
+	hit.Snippet = highlight(snippet(body, terms), terms)
+	return hit
+}
+
+// matchesTerm checks whether a word starts with any of the search terms.
+func matchesTerm(word string, terms []string) bool {
+	word = strings.ToLower(word)
+	for _, t := range terms {
+		if strings.HasPrefix(word, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// snippet cuts an excerpt of s around the first word matching the terms.
+func snippet(s string, terms []string) string {
+	words := strings.Fields(s)
+	if len(words) <= snippetWords {
+		return strings.Join(words, " ")
+	}
+
+	first := 0
+	for i, w := range words {
+		if matchesTerm(strings.TrimFunc(w, isNotWordChar), terms) {
+			first = i
+			break
+		}
+	}
+
+	start := first - snippetWords/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+		start = end - snippetWords
+	}
+
+	excerpt := strings.Join(words[start:end], " ")
+	if start > 0 {
+		excerpt = "…" + excerpt
+	}
+	if end < len(words) {
+		excerpt += "…"
+	}
+	return excerpt
+}
+
+func isNotWordChar(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlight escapes s and wraps the words matching the terms in <mark>.
+func highlight(s string, terms []string) template.HTML {
+	var out, word strings.Builder
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		if matchesTerm(word.String(), terms) {
+			out.WriteString("<mark>" + template.HTMLEscapeString(word.String()) + "</mark>")
+		} else {
+			out.WriteString(template.HTMLEscapeString(word.String()))
+		}
+		word.Reset()
+	}
+
+	for _, r := range s {
+		if isNotWordChar(r) {
+			flush()
+			out.WriteString(template.HTMLEscapeString(string(r)))
+		} else {
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return template.HTML(out.String())
+}
+
+// search runs a query and prepares its results for display.
+func search(q string) ([]searchHit, error) {
+	results, err := models.Search(q, searchLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := models.SearchTerms(q)
+	hits := make([]searchHit, 0, len(results))
+	for _, r := range results {
+		hits = append(hits, newSearchHit(r, terms))
+	}
+	return hits, nil
+}
+
+// SearchHandler response for the search page.
+func SearchHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	q := ctx.QueryTrim("q")
+	if q != "" {
+		hits, err := search(q)
+		if err != nil {
+			log.Println(err)
+			f.Error("There was an error searching!")
+		}
+		ctx.Data["Results"] = hits
+		ctx.Data["Title"] = q + " - Search"
+	} else {
+		ctx.Data["Title"] = "Search"
+	}
+
+	ctx.Data["IsSearch"] = 1
+	ctx.Data["Query"] = q
+	ctx.Data["HasScope"] = 1
+	ctx.HTML(200, "search")
+}
+
+// APISearchHandler response for searching tickets, comments and
+// announcements.
+func APISearchHandler(ctx *emmanuel.Context) {
+	q := ctx.QueryTrim("q")
+	if q == "" {
+		apiFail(ctx, http.StatusBadRequest, "Missing search query")
+		return
+	}
+
+	hits, err := search(q)
+	if err != nil {
+		log.Println(err)
+		apiFail(ctx, http.StatusInternalServerError, "Failed to search")
+		return
+	}
+
+	page, perPage := apiPaging(ctx)
+	start, end := (page-1)*perPage, page*perPage
+	if start > len(hits) {
+		start = len(hits)
+	}
+	if end > len(hits) {
+		end = len(hits)
+	}
+
+	ctx.JSON(http.StatusOK, apiList{
+		Data:       hits[start:end],
+		Pagination: newAPIPagination(page, perPage, int64(len(hits))),
+	})
+}
+
+// APISimilarTicketsHandler response for finding open tickets similar to a
+// ticket being written, so students can upvote one instead of posting a
+// duplicate.
+func APISimilarTicketsHandler(ctx *emmanuel.Context) {
+	title, text := ctx.QueryTrim("title"), ctx.QueryTrim("text")
+	if title == "" && text == "" {
+		apiFail(ctx, http.StatusBadRequest, "Missing title")
+		return
+	}
+
+	tickets := models.GetSimilarTickets(title, text, similarLimit)
+	list := make([]apiTicket, len(tickets))
+	for i := range tickets {
+		list[i] = newAPITicket(&tickets[i])
+	}
+	ctx.JSON(http.StatusOK, apiList{
+		Data:       list,
+		Pagination: newAPIPagination(1, similarLimit, int64(len(list))),
+	})
+}
@@ -3,12 +3,14 @@ package routes
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
-	"sort"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/go-emmanuel/csrf"
@@ -23,62 +25,215 @@ import (
 	"github.com/yuin/goldmark/renderer/html"
 )
 
+// ticketsPerPage is the number of tickets on a page of the tickets listing.
+const ticketsPerPage = 20
+
 func getUsedCourses() (courses []config.Course) {
+	used := make(map[string]bool)
+	for _, c := range models.GetUsedCategories() {
+		used[c] = true
+	}
 	for _, c := range config.Config.InstanceConfig.Courses {
-		if models.HasTicketWithCategory(c.Code) {
+		if used[c.Code] {
 			courses = append(courses, c)
 		}
 	}
 	return
 }
 
-func isCourseOfDegree(code string, deg string) bool {
+// getCoursesOfDegree returns the codes of the courses of a degree.
+func getCoursesOfDegree(deg string) (codes []string) {
 	for _, c := range config.Config.InstanceConfig.Courses {
-		if c.Code == code {
-			for _, d := range c.DegreeCode {
-				if d == deg {
-					return true
-				}
+		for _, d := range c.DegreeCode {
+			if d == deg {
+				codes = append(codes, c.Code)
+				break
 			}
+		}
+	}
+	return
+}
 
-			break
+// parseDate parses a date in the format used by date inputs, returning the
+// start of the day, and the start of the next day if end is set.
+func parseDate(s string, end bool) (int64, error) {
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.Unix(), nil
+}
+
+// ticketFilter builds a ticket filter from the category or degree and the
+// status and date range query parameters. The status may be a ticket status,
+// "active", "closed" or "all", and defaults to def.
+func ticketFilter(category, degree, status, from, to, def string) (models.TicketFilter, error) {
+	var filter models.TicketFilter
+	if category != "" {
+		if !hasCategory(category) {
+			return filter, errors.New("Can't sort by that category")
+		}
+		filter.Categories = []string{category}
+	} else if degree != "" {
+		if !hasDegree(degree) {
+			return filter, errors.New("Can't sort by that degree")
 		}
+		filter.Categories = getCoursesOfDegree(degree)
 	}
-	return false
+
+	if status == "" {
+		status = def
+	}
+	switch status {
+	case "active":
+		filter.Statuses = models.ActiveStatuses()
+	case "closed":
+		filter.Statuses = models.ClosedStatuses()
+	case "all":
+	default:
+		if !models.TicketStatus(status).IsValid() {
+			return filter, errors.New("Unknown status")
+		}
+		filter.Statuses = []models.TicketStatus{models.TicketStatus(status)}
+	}
+
+	var err error
+	if from != "" {
+		if filter.From, err = parseDate(from, false); err != nil {
+			return filter, errors.New("Invalid start date")
+		}
+	}
+	if to != "" {
+		if filter.To, err = parseDate(to, true); err != nil {
+			return filter, errors.New("Invalid end date")
+		}
+		filter.To-- // the end date is inclusive
+	}
+	return filter, nil
+}
+
+// ticketSort parses the order to list tickets in, which defaults to hot.
+func ticketSort(s string) (models.TicketSort, error) {
+	if s == "" {
+		return models.SortHot, nil
+	}
+	if !models.TicketSort(s).IsValid() {
+		return "", errors.New("Unknown sort")
+	}
+	return models.TicketSort(s), nil
+}
+
+// sortLink is a link to list tickets in another order.
+type sortLink struct {
+	Name    string
+	URL     string
+	Current bool
+}
+
+// withQuery returns the current path with some query parameters replaced.
+// Parameters with an empty value are removed.
+func withQuery(ctx *emmanuel.Context, params ...string) string {
+	q := ctx.Req.URL.Query()
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i+1] == "" {
+			q.Del(params[i])
+		} else {
+			q.Set(params[i], params[i+1])
+		}
+	}
+	if len(q) == 0 {
+		return ctx.Req.URL.Path
+	}
+	return ctx.Req.URL.Path + "?" + q.Encode()
 }
 
 // TicketsHandler response for the tickets listing page.
 func TicketsHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash, x csrf.CSRF) {
-	var tickets []models.Ticket
-
-	if ctx.Params("category") != "" {
-		tickets = models.GetCategory(ctx.Params("category"))
-	} else if ctx.Params("degree") != "" {
-		t := models.GetTickets()
-		for i, tic := range t {
-			if isCourseOfDegree(tic.Category, ctx.Params("degree")) {
-				tickets = append(tickets, t[i])
-			}
+	filter, err := ticketFilter(ctx.Params("category"), ctx.Params("degree"),
+		ctx.Query("status"), ctx.Query("from"), ctx.Query("to"), "active")
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets")
+		return
+	}
+	if name := ctx.Params("label"); name != "" {
+		label, err := models.GetLabelByName(name)
+		if err != nil {
+			f.Error(errNoLabel.Error())
+			ctx.Redirect("/tickets")
+			return
 		}
-	} else {
-		tickets = models.GetTickets()
+		filter.Label = label.LabelID
+		ctx.Data["Label"] = label
+	}
+	filter.Unanswered = ctx.QueryBool("unanswered")
+	by, err := ticketSort(ctx.Query("sort"))
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets")
+		return
 	}
 
-	sort.Sort(models.HotTickets(tickets))
-	hasResolved := false
+	ctx.Data["Title"] = "Tickets"
+	renderTickets(ctx, filter, by, x)
+}
 
-	for i := range tickets {
-		tickets[i].LoadComments()
-		tickets[i].CommentsCount = len(tickets[i].Comments)
-		if tickets[i].IsResolved {
-			hasResolved = true
-		}
+// renderTickets renders a page of the tickets matching the filter, in the
+// order of the sort.
+func renderTickets(ctx *emmanuel.Context, filter models.TicketFilter, by models.TicketSort, x csrf.CSRF) {
+	page := ctx.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	total := models.CountTickets(filter)
+	totalPages := int((total + ticketsPerPage - 1) / ticketsPerPage)
+
+	tickets := models.FindTickets(filter, by, (page-1)*ticketsPerPage, ticketsPerPage)
+	if err := models.LoadCommentsCounts(tickets); err != nil {
+		log.Println(err)
+	}
+	if err := models.LoadLabels(tickets); err != nil {
+		log.Println(err)
+	}
+
+	status := ctx.Query("status")
+	if status == "" && ctx.Data["IsArchive"] == 1 {
+		status = "all"
+	} else if status == "" {
+		status = "active"
 	}
 
 	ctx.Data["Tickets"] = tickets
 	ctx.Data["IsTickets"] = 1
-	ctx.Data["HasResolved"] = hasResolved
-	ctx.Data["Title"] = "Tickets"
+	ctx.Data["Status"] = status
+	ctx.Data["Statuses"] = models.TicketStatuses
+	ctx.Data["ActiveURL"] = withQuery(ctx, "status", "", "page", "")
+	ctx.Data["ClosedURL"] = withQuery(ctx, "status", "closed", "page", "")
+	ctx.Data["AllURL"] = withQuery(ctx, "status", "all", "page", "")
+	var sorts []sortLink
+	for _, s := range models.TicketSorts {
+		value := string(s)
+		if s == models.SortHot {
+			value = "" // the default is left out of the URL
+		}
+		sorts = append(sorts, sortLink{s.Name(), withQuery(ctx, "sort", value, "page", ""), s == by})
+	}
+	ctx.Data["Sorts"] = sorts
+	ctx.Data["Sort"] = ctx.Query("sort")
+	ctx.Data["From"] = ctx.Query("from")
+	ctx.Data["To"] = ctx.Query("to")
+	ctx.Data["Unanswered"] = filter.Unanswered
+	ctx.Data["Page"] = page
+	ctx.Data["TotalPages"] = totalPages
+	if page > 1 {
+		ctx.Data["PrevURL"] = withQuery(ctx, "page", strconv.Itoa(page-1))
+	}
+	if page < totalPages {
+		ctx.Data["NextURL"] = withQuery(ctx, "page", strconv.Itoa(page+1))
+	}
 	ctx.Data["Category"] = ctx.Params("category")
 	ctx.Data["Degree"] = ctx.Params("degree")
 	ctx.Data["Courses"] = getUsedCourses()
@@ -112,20 +267,53 @@ func markdownToHTML(s string) string {
 func TicketPageHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash, x csrf.CSRF) {
 	ctx.Data["IsTickets"] = 1
 	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
-	if err != nil {
-		log.Println(err)
+	if err != nil || (isHiddenTicket(ticket, sess) && !hasTicketToken(ticket, ctx.Query("token"))) {
+		f.Error("Ticket not found!")
 		ctx.Redirect("/tickets")
 		return
 	}
+	if ticket.DuplicateOf != 0 {
+		f.Info(fmt.Sprintf("Ticket #%d was a duplicate and has been merged into this ticket.", ticket.TicketID))
+		redirectDuplicate(ctx, ticket)
+		return
+	}
 	ctx.Data["Title"] = ticket.Title + " - Ticket"
 	ctx.Data["Description"] = summariseMarkdown(ticket.Description)
 
 	ctx.Data["csrf_token"] = x.GetToken()
 	ctx.Data["FormattedPost"] = template.HTML(markdownToHTML(ticket.Description))
 	ticket.LoadComments()
+	models.MarkPosterComments(ticket.TicketID, ticket.Comments)
+	// Comments waiting for moderation are only shown to class representatives.
+	if ctx.Data["IsAdmin"] != 1 {
+		ticket.Comments = visibleComments(ticket.Comments)
+	}
+	for i := range ticket.Comments {
+		if c := &ticket.Comments[i]; c.CommentID == ticket.AnswerID {
+			ctx.Data["Answer"] = c
+		}
+	}
 	ctx.Data["Ticket"] = ticket
+	ctx.Data["Comments"] = models.ThreadComments(ticket.Comments)
 	voterHash := userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent"))
-	ctx.Data["Upvoted"] = containsString(voterHash, ticket.Voters)
+	ctx.Data["Upvoted"] = models.HasVoted(ticket.TicketID, voterHash)
+	ctx.Data["CanVote"] = !ticket.Status.IsClosed() && !ticket.IsLocked && !ticket.IsArchived() && !ticket.IsPending
+	// Class representatives can still comment on locked or pending tickets.
+	ctx.Data["CanComment"] = !ticket.IsArchived() &&
+		(!ticket.IsLocked && !ticket.IsPending || ctx.Data["IsAdmin"] == 1)
+	ctx.Data["Polls"] = getPollViews(models.GetTicketPolls(ticket.TicketID), voterHash,
+		ctx.Data["IsAdmin"] == 1, canVotePolls(ticket))
+	ctx.Data["StatusChanges"] = models.GetStatusChanges(ticket.TicketID)
+	ctx.Data["Escalations"] = models.GetEscalations(ticket.TicketID)
+	ctx.Data["PollTypes"] = models.PollTypes
+	ticket.Labels = models.GetTicketLabels(ticket.TicketID)
+	assignees := models.GetAssignees(ticket.TicketID)
+	ctx.Data["Assignees"] = getClassRepsByEmails(assignees)
+	if ctx.Data["IsAdmin"] == 1 {
+		ctx.Data["ClassReps"] = getRepAssignments(assignees)
+		ctx.Data["LabelCheckboxes"] = getLabelCheckboxes(ticket.Labels)
+		ctx.Data["Lecturers"] = getLecturersByCourseCode(ticket.Category)
+	}
 	ctx.Data["HasScope"] = 1
 	ctx.HTML(200, "ticket")
 }
@@ -138,11 +326,25 @@ func IsImproperChar(r rune) bool {
 // PostTicketPageHandler handles posting a new comment on a ticket.
 func PostTicketPageHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
 	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
-	if err != nil {
+	if err != nil || isHiddenTicket(ticket, sess) {
 		log.Println(err)
 		ctx.Redirect("/tickets")
 		return
 	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+	if ticket.IsArchived() {
+		f.Error(errTicketArchived.Error())
+		ctx.Redirect("/tickets/" + ctx.Params("id"))
+		return
+	}
+	// Class representatives can still comment on locked tickets.
+	if ticket.IsLocked && sess.Get("isadmin") != 1 {
+		f.Error(errTicketLocked.Error())
+		ctx.Redirect("/tickets/" + ctx.Params("id"))
+		return
+	}
 
 	text := strings.TrimFunc(ctx.QueryTrim("text"), IsImproperChar)
 	if len(text) == 0 {
@@ -150,29 +352,79 @@ func PostTicketPageHandler(ctx *emmanuel.Context, sess session.Store, f *session
 		ctx.Redirect("/tickets/" + ctx.Params("id"))
 		return
 	}
+	if err = checkRateLimit(ctx, sess, models.ActionComment); err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets/" + ctx.Params("id"))
+		return
+	}
 
 	comment := models.Comment{
 		TicketID: ticket.TicketID,
-		PosterID: sess.Get("id").(string),
 		Text:     text,
 	}
+	if parentID := ctx.QueryInt64("parent"); parentID != 0 {
+		parent, err := models.GetComment(parentID)
+		if err != nil || parent.TicketID != ticket.TicketID || parent.IsDeleted || parent.IsPending {
+			f.Error("The comment you replied to was not found!")
+			ctx.Redirect("/tickets/" + ctx.Params("id"))
+			return
+		}
+		comment.ReplyTo(parent)
+	}
 
 	if sess.Get("isadmin") == 1 && ctx.Query("as_admin") == "on" {
 		comment.IsAdmin = true
 		comment.PosterID = ctx.Data["User"].(config.ClassRepresentative).Name
 	}
+	if comment.PendingReason, err = filterContent(sess, "", text); err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets/" + ctx.Params("id"))
+		return
+	}
+	comment.IsPending = comment.PendingReason != ""
 
-	err = models.AddComment(&comment)
+	err = addComment(&comment, ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")))
 	if err != nil {
 		log.Println(err)
+	} else {
+		recordRateLimit(ctx, sess, models.ActionComment)
+		if comment.IsPending {
+			f.Info("Your comment will be shown once a class representative has approved it.")
+		}
 	}
 	ctx.Redirect("/tickets/" + ctx.Params("id"))
 }
 
+// addComment posts a comment on a ticket, telling its followers if it was
+// posted by a class representative. Students post under their pseudonym in
+// the ticket.
+func addComment(comment *models.Comment, ticket *models.Ticket, voterHash string) (err error) {
+	if !comment.IsAdmin {
+		if comment.PosterID, err = models.GetPseudonym(ticket.TicketID, voterHash); err != nil {
+			return err
+		}
+	}
+	if err = models.AddComment(comment); err != nil {
+		return err
+	}
+	if comment.IsAdmin {
+		notifyFollowers(ticket, comment.PosterID+" (class representative) replied:\n\n"+comment.Text)
+	}
+	return nil
+}
+
 // PostTicketSortHandler handles redirecting to a page of filtered tickets by category
 func PostTicketSortHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
 	category := ctx.Query("category")
 
+	// Keep the status and date range, but start from the first page.
+	q := ctx.Req.URL.Query()
+	q.Del("page")
+	query := ""
+	if len(q) > 0 {
+		query = "?" + q.Encode()
+	}
+
 	switch ctx.Query("type") {
 	case "category":
 		if !hasCategory(category) {
@@ -181,7 +433,7 @@ func PostTicketSortHandler(ctx *emmanuel.Context, sess session.Store, f *session
 			return
 		}
 
-		ctx.Redirect("/tickets/cat/" + category)
+		ctx.Redirect("/tickets/cat/" + url.PathEscape(category) + query)
 	case "degree":
 		if !hasDegree(category) {
 			f.Error("Can't sort by that degree")
@@ -189,7 +441,7 @@ func PostTicketSortHandler(ctx *emmanuel.Context, sess session.Store, f *session
 			return
 		}
 
-		ctx.Redirect("/tickets/deg/" + category)
+		ctx.Redirect("/tickets/deg/" + url.PathEscape(category) + query)
 	default:
 		f.Error("Unknown filter")
 		ctx.Redirect("/tickets")
@@ -201,7 +453,19 @@ func NewTicketHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flas
 	ctx.Data["IsTickets"] = 1
 	ctx.Data["csrf_token"] = x.GetToken()
 	ctx.Data["Courses"] = config.Config.InstanceConfig.Courses
+	// Without JavaScript, similar tickets are looked up by submitting the
+	// form here instead.
+	if title, text := ctx.QueryTrim("title"), ctx.QueryTrim("text"); title != "" || text != "" {
+		ctx.Data["ptitle"] = title
+		ctx.Data["ptext"] = text
+		if hasCategory(ctx.Query("category")) {
+			ctx.Data["pcategory"] = ctx.Query("category")
+		}
+		ctx.Data["Similar"] = models.GetSimilarTickets(title, text, similarLimit)
+		ctx.Data["SimilarChecked"] = 1
+	}
 	ctx.Data["HasScope"] = 1
+	setAttachmentLimits(ctx)
 	ctx.HTML(200, "new-ticket")
 }
 
@@ -225,48 +489,110 @@ func hasDegree(deg string) bool {
 	return false
 }
 
+var (
+	errInvalidCategory = errors.New("There was an error in creating your ticket")
+	errEmptyTicket     = errors.New("Title or body cannot be empty!")
+	errLongTicket      = errors.New("Title or body is too long!")
+	errTicketLocked    = errors.New("This ticket is locked by the class representatives!")
+	errPendingMerge    = errors.New("A ticket waiting for moderation must be approved before it is merged!")
+)
+
+// validateTicket checks the title, body and category of a new ticket against
+// the limits of the platform.
+func validateTicket(title, text, category string) error {
+	if !hasCategory(category) {
+		return errInvalidCategory
+	}
+	if len(title) == 0 || len(text) < 4 {
+		return errEmptyTicket
+	}
+	if len(title) > 80 || len(text) > 2048 {
+		return errLongTicket
+	}
+	return nil
+}
+
 // PostNewTicketHandler post response for posting new ticket.
 func PostNewTicketHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
 	title := strings.TrimFunc(ctx.QueryTrim("title"), IsImproperChar)
 	text := strings.TrimFunc(ctx.QueryTrim("text"), IsImproperChar)
 	category := ctx.QueryTrim("category")
 
-	if !hasCategory(category) {
-		f.Error("There was an error in creating your ticket")
-		ctx.Redirect("/tickets")
+	if err := validateTicket(title, text, category); err != nil {
+		f.Error(err.Error())
+		if err == errInvalidCategory {
+			ctx.Redirect("/tickets")
+		} else {
+			ctx.Redirect("/tickets/new")
+		}
 		return
 	}
-
-	voterHash := userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent"))
-
-	if len(title) == 0 || len(text) < 4 {
-		f.Error("Title or body cannot be empty!")
+	if err := checkRateLimit(ctx, sess, models.ActionTicket); err != nil {
+		f.Error(err.Error())
+		ctx.Redirect("/tickets/new")
+		return
+	}
+	reason, err := filterContent(sess, title, text)
+	if err != nil {
+		f.Error(err.Error())
 		ctx.Redirect("/tickets/new")
 		return
 	}
 
-	if len(title) > 80 || len(text) > 2048 {
-		f.Error("Title or body is too long!")
+	attachments, hashes, err := saveAttachments(ctx, ticketAttachmentTypes)
+	if err != nil {
+		f.Error(err.Error())
 		ctx.Redirect("/tickets/new")
 		return
 	}
 
 	ticket := models.Ticket{
 		Title:       title,
-		Description: text,
-		Voters:      []string{voterHash},
+		Description: text + attachments,
 		Category:    category,
 	}
-	err := models.AddTicket(&ticket)
+	err = holdNewTicket(&ticket, sess, reason)
+	if err == nil {
+		err = addTicket(&ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")))
+	}
+	if err == nil {
+		err = models.AddAttachmentOwners(hashes, ticket.TicketID, 0)
+	}
 	if err != nil {
 		log.Println(err)
 		f.Error("Failed to add ticket")
 		ctx.Redirect("/tickets")
 		return
 	}
+	recordRateLimit(ctx, sess, models.ActionTicket)
+	if ticket.IsPending {
+		f.Info("Your ticket will be shown once a class representative has approved it. " +
+			"Until then, only you can see it at this private link, so bookmark it to check on it.")
+		ctx.Redirect(privateTicketURL(&ticket))
+		return
+	}
 	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
 }
 
+// addTicket inserts a new ticket, upvoted by its poster and assigned to the
+// class representatives of its course. The poster gets their pseudonym in the
+// ticket, marking their comments as the original poster's.
+func addTicket(ticket *models.Ticket, voterHash string) error {
+	if err := models.AddTicket(ticket); err != nil {
+		return err
+	}
+	if err := models.AddRevision(nil, models.TicketRevision(ticket)); err != nil {
+		return err
+	}
+	if _, err := models.AddVote(ticket, voterHash); err != nil {
+		return err
+	}
+	if err := models.SetTicketPoster(ticket.TicketID, voterHash); err != nil {
+		return err
+	}
+	return autoAssignTicket(ticket)
+}
+
 func userHash(ip string, useragent string) string {
 	h := sha256.New()
 	//h.Write([]byte(ip + useragent + config.Config.VoterPepper))
@@ -282,69 +608,123 @@ func getIP(ctx *emmanuel.Context) string {
 	return ctx.RemoteAddr()
 }
 
-func containsString(s string, arr []string) bool {
-	for _, v := range arr {
-		if v == s {
-			return true
-		}
-	}
-	return false
-}
-
 // UpvoteTicketHandler response for upvoting a specific ticket.
 func UpvoteTicketHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
 	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
-	if err != nil {
+	if err != nil || isHiddenTicket(ticket, sess) {
 		log.Println(err)
 		ctx.Redirect("/tickets")
 		return
 	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
 
-	voterHash := userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent"))
-	if !ticket.IsResolved && !containsString(voterHash, ticket.Voters) {
-		ticket.Voters = append(ticket.Voters, voterHash)
-		models.UpdateTicketCols(ticket, "voters")
+	if ticket.IsArchived() {
+		f.Error(errTicketArchived.Error())
+	} else if ticket.IsLocked {
+		f.Error(errTicketLocked.Error())
+	} else if !ticket.Status.IsClosed() {
+		if err = checkRateLimit(ctx, sess, models.ActionVote); err != nil {
+			f.Error(err.Error())
+		} else if added, err := models.AddVote(ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent"))); err != nil {
+			log.Println(err)
+		} else if added {
+			recordRateLimit(ctx, sess, models.ActionVote)
+		}
 	}
 
 	ctx.Redirect("/tickets/" + strconv.Itoa(ctx.ParamsInt("id")))
 }
 
-// ResolveTicketHandler response for resolving and unresolving a specific ticket.
-func ResolveTicketHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+// RetractVoteHandler response for retracting the upvote of a specific ticket.
+func RetractVoteHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
 	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
-	if err != nil {
+	if err != nil || isHiddenTicket(ticket, sess) {
 		log.Println(err)
 		ctx.Redirect("/tickets")
 		return
 	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
 
-	ticket.IsResolved = !ticket.IsResolved
-	models.UpdateTicketCols(ticket, "is_resolved")
+	if ticket.IsArchived() {
+		f.Error(errTicketArchived.Error())
+	} else if ticket.IsLocked {
+		f.Error(errTicketLocked.Error())
+	} else if !ticket.Status.IsClosed() {
+		_, err = models.RemoveVote(ticket, userHash(getIP(ctx), ctx.Req.Header.Get("User-Agent")))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	ctx.Redirect("/tickets/" + strconv.Itoa(ctx.ParamsInt("id")))
+}
+
+// changeTicketStatus moves a ticket to another status and logs it as done by
+// the admin.
+func changeTicketStatus(ticket *models.Ticket, to models.TicketStatus, note, admin string) error {
+	if ticket.IsArchived() {
+		return errTicketArchived
+	}
+	from := ticket.Status
+	if err := models.ChangeTicketStatus(ticket, to, note, admin); err != nil {
+		return err
+	}
 
 	m := models.Moderation{
-		Admin: ctx.Data["User"].(config.ClassRepresentative).Name,
-		Title: "Ticket \"" + ticket.Title + "\"",
+		Admin:       admin,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Changed status from \"" + from.Name() + "\" to \"" + to.Name() + "\"",
+		Reason:      note,
+	}
+	models.AddModeration(&m)
+
+	if to == models.StatusResolved {
+		notifyFollowers(ticket, "The ticket was resolved by "+admin+":\n\n"+note)
+	}
+	return nil
+}
+
+// PostTicketStatusHandler response for changing the status of a specific
+// ticket.
+func PostTicketStatusHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		log.Println(err)
+		ctx.Redirect("/tickets")
+		return
 	}
-	if ticket.IsResolved {
-		m.Description = "Marked ticket as resolved"
+
+	err = changeTicketStatus(ticket, models.TicketStatus(ctx.QueryTrim("status")),
+		ctx.QueryTrim("note"), ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		f.Error(err.Error())
 	} else {
-		m.Description = "Marked ticket as unresolved"
+		f.Success("Ticket is now " + ticket.Status.Name())
 	}
-	models.AddModeration(&m)
 
 	ctx.Redirect("/tickets/" + strconv.Itoa(ctx.ParamsInt("id")))
 }
 
 // PostTicketEditHandler response for adding posting new ticket.
 func PostTicketEditHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash, x csrf.CSRF) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		log.Println(err)
+		ctx.Redirect("/tickets")
+		return
+	}
+	if ticket.IsArchived() {
+		f.Error(errTicketArchived.Error())
+		ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+		return
+	}
+
 	if ctx.QueryTrim("title") == "" || ctx.QueryTrim("text") == "" {
 		ctx.Data["IsTickets"] = 1
-		ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
-		if err != nil {
-			log.Println(err)
-			ctx.Redirect("/tickets")
-			return
-		}
 		ctx.Data["csrf_token"] = x.GetToken()
 		ctx.Data["Ticket"] = ticket
 		ctx.Data["ptitle"] = ticket.Title
@@ -356,11 +736,6 @@ func PostTicketEditHandler(ctx *emmanuel.Context, sess session.Store, f *session
 		ctx.HTML(200, "new-ticket")
 		return
 	}
-	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
-	if err != nil {
-		ctx.Redirect(fmt.Sprintf("/tickets/%d", ctx.ParamsInt64("id")))
-		return
-	}
 
 	m := models.Moderation{
 		Admin: ctx.Data["User"].(config.ClassRepresentative).Name,
@@ -403,39 +778,233 @@ func PostTicketEditHandler(ctx *emmanuel.Context, sess session.Store, f *session
 	models.AddModeration(&m)
 
 	// TODO update category
-	err = models.UpdateTicket(&models.Ticket{
+	edited := &models.Ticket{
 		TicketID:    ticket.TicketID,
 		Title:       title,
 		Description: text,
 		Category:    category,
-	})
+	}
+	err = models.UpdateTicket(edited)
 	if err != nil {
 		panic(err)
 	}
+	if modDesc.Len() > 0 {
+		r := models.TicketRevision(edited)
+		r.Admin = m.Admin
+		r.IsSensitive = m.DescriptionSensitive
+		if err = models.AddRevision(models.TicketRevision(ticket), r); err != nil {
+			log.Println(err)
+		}
+	}
 
 	ctx.Redirect(fmt.Sprintf("/tickets/%d", ctx.ParamsInt64("id")))
 }
 
-// PostTicketDeleteHandler response for deleting a ticket.
-func PostTicketDeleteHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
-	t, err := models.GetTicket(ctx.ParamsInt64("id"))
+// redirectDuplicate redirects to the ticket a duplicate was merged into, and
+// returns whether the ticket was a duplicate.
+func redirectDuplicate(ctx *emmanuel.Context, ticket *models.Ticket) bool {
+	if ticket.DuplicateOf == 0 {
+		return false
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.DuplicateOf))
+	return true
+}
+
+// mergeTicket merges a duplicate ticket into a canonical ticket and logs it as
+// done by the admin.
+func mergeTicket(dup, canonical *models.Ticket, reason, admin string) error {
+	if dup.IsArchived() {
+		return errTicketArchived
+	}
+	if dup.IsPending {
+		return errPendingMerge
+	}
+	votes, comments, err := models.MergeTicket(dup, canonical)
+	if err != nil {
+		return err
+	}
+
+	m := models.Moderation{
+		Admin: admin,
+		Title: "Ticket \"" + dup.Title + "\"",
+		Description: fmt.Sprintf("Marked as a duplicate of #%d \"%s\", merging %d votes and %d comments",
+			canonical.TicketID, canonical.Title, votes, comments),
+		Reason: reason,
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostTicketDuplicateHandler response for marking a ticket as a duplicate of
+// another ticket.
+func PostTicketDuplicateHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	dup, err := models.GetTicket(ctx.ParamsInt64("id"))
 	if err != nil {
 		f.Error("Ticket not found!")
 		ctx.Redirect("/tickets")
 		return
 	}
+	canonical, err := models.GetTicket(int64(ctx.QueryInt("of")))
+	if err != nil {
+		f.Error("The ticket to merge into was not found!")
+		ctx.Redirect(fmt.Sprintf("/tickets/%d", dup.TicketID))
+		return
+	}
+
+	err = mergeTicket(dup, canonical, ctx.QueryTrim("reason"), ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		f.Error(err.Error())
+		ctx.Redirect(fmt.Sprintf("/tickets/%d", dup.TicketID))
+		return
+	}
+
+	f.Success(fmt.Sprintf("Ticket #%d was merged into this ticket.", dup.TicketID))
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", canonical.TicketID))
+}
+
+// pinTicket pins or unpins a ticket and logs it as done by the admin.
+func pinTicket(ticket *models.Ticket, pinned bool, reason, admin string) error {
+	if ticket.IsArchived() {
+		return errTicketArchived
+	}
+	if ticket.IsPinned == pinned {
+		if pinned {
+			return errors.New("The ticket is already pinned!")
+		}
+		return errors.New("The ticket is not pinned!")
+	}
+	if err := models.SetTicketPinned(ticket, pinned); err != nil {
+		return err
+	}
+
 	m := models.Moderation{
-		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
+		Admin:       admin,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Unpinned",
+		Reason:      reason,
+	}
+	if pinned {
+		m.Description = "Pinned"
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// lockTicket locks or unlocks a ticket and logs it as done by the admin.
+func lockTicket(ticket *models.Ticket, locked bool, reason, admin string) error {
+	if ticket.IsArchived() {
+		return errTicketArchived
+	}
+	if ticket.IsLocked == locked {
+		if locked {
+			return errors.New("The ticket is already locked!")
+		}
+		return errors.New("The ticket is not locked!")
+	}
+	if err := models.SetTicketLocked(ticket, locked); err != nil {
+		return err
+	}
+
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Ticket \"" + ticket.Title + "\"",
+		Description: "Unlocked",
+		Reason:      reason,
+	}
+	if locked {
+		m.Description = "Locked, stopping new comments and votes"
+	}
+	models.AddModeration(&m)
+	return nil
+}
+
+// PostTicketPinHandler response for pinning or unpinning a ticket.
+func PostTicketPinHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+
+	pinned := ctx.QueryBool("pinned")
+	err = pinTicket(ticket, pinned, ctx.QueryTrim("reason"), ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		f.Error(err.Error())
+	} else if pinned {
+		f.Success("Ticket pinned!")
+	} else {
+		f.Success("Ticket unpinned!")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
+
+// PostTicketLockHandler response for locking or unlocking a ticket.
+func PostTicketLockHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	ticket, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	if redirectDuplicate(ctx, ticket) {
+		return
+	}
+
+	locked := ctx.QueryBool("locked")
+	err = lockTicket(ticket, locked, ctx.QueryTrim("reason"), ctx.Data["User"].(config.ClassRepresentative).Name)
+	if err != nil {
+		f.Error(err.Error())
+	} else if locked {
+		f.Success("Ticket locked!")
+	} else {
+		f.Success("Ticket unlocked!")
+	}
+	ctx.Redirect(fmt.Sprintf("/tickets/%d", ticket.TicketID))
+}
+
+// deleteTicket deletes a ticket and logs it as done by the admin.
+func deleteTicket(t *models.Ticket, admin string) {
+	m := models.Moderation{
+		Admin:       admin,
 		Title:       "Ticket \"" + t.Title + "\"",
 		Description: "Deleted",
 	}
 	models.AddModeration(&m)
 
-	models.DelTicket(ctx.ParamsInt64("id"))
+	models.DelTicket(t.TicketID)
+	removeUnusedAttachments()
+}
+
+// PostTicketDeleteHandler response for deleting a ticket.
+func PostTicketDeleteHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
+	t, err := models.GetTicket(ctx.ParamsInt64("id"))
+	if err != nil {
+		f.Error("Ticket not found!")
+		ctx.Redirect("/tickets")
+		return
+	}
+	deleteTicket(t, ctx.Data["User"].(config.ClassRepresentative).Name)
 	f.Success("Ticket deleted!")
 	ctx.Redirect("/tickets")
 }
 
+// deleteComment deletes a comment of a ticket and logs it as done by the
+// admin.
+func deleteComment(c *models.Comment, t *models.Ticket, admin string) {
+	m := models.Moderation{
+		Admin:       admin,
+		Title:       "Comment by \"" + c.PosterID + "\" on \"" + t.Title + "\"",
+		Description: "Deleted",
+	}
+	models.AddModeration(&m)
+
+	models.DeleteComment(c.CommentID)
+}
+
 // PostCommentDeleteHandler response for deleting a ticket's comment.
 func PostCommentDeleteHandler(ctx *emmanuel.Context, sess session.Store, f *session.Flash) {
 	c, err := models.GetComment(ctx.ParamsInt64("cid"))
@@ -450,14 +1019,7 @@ func PostCommentDeleteHandler(ctx *emmanuel.Context, sess session.Store, f *sess
 		ctx.Redirect("/tickets")
 		return
 	}
-	m := models.Moderation{
-		Admin:       ctx.Data["User"].(config.ClassRepresentative).Name,
-		Title:       "Comment by \"" + c.PosterID + "\" on \"" + t.Title + "\"",
-		Description: "Deleted",
-	}
-	models.AddModeration(&m)
-
+	deleteComment(c, t, ctx.Data["User"].(config.ClassRepresentative).Name)
 	f.Success("Comment deleted!")
-	models.DeleteComment(ctx.ParamsInt64("cid"))
 	ctx.Redirect(fmt.Sprintf("/tickets/%d", ctx.ParamsInt64("id")))
 }